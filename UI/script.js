@@ -1,5 +1,6 @@
 const API_BASE_URL = ""; // Empty for relative URLs (works on same host)
-const COOLDOWN_SECONDS = 2; // Cooldown between hits
+const COOLDOWN_SECONDS = 2; // Fallback cooldown when the server sends no rate-limit headers
+const SESSION_KEY = "cricketSession"; // localStorage key for the login session
 let isButtonDisabled = false;
 
 // Validate roll number (must be exactly 10 digits)
@@ -7,8 +8,19 @@ function validateRollNumber(rollNumber) {
     return /^\d{10}$/.test(rollNumber);
 }
 
-// Show shot animation
-function showShotAnimation(shot) {
+// CSS class for each result the server can return
+const OUTCOME_CLASSES = {
+    "dot": "shot-dot",
+    "1": "shot-run",
+    "2": "shot-run",
+    "3": "shot-run",
+    "4": "shot-four",
+    "6": "shot-six",
+    "out": "shot-out"
+};
+
+// Show shot animation for the outcome decided by the server
+function showShotAnimation(outcome) {
     // Remove existing animation if any
     const existing = document.getElementById("shot-animation");
     if (existing) existing.remove();
@@ -16,8 +28,10 @@ function showShotAnimation(shot) {
     // Create animation overlay
     const overlay = document.createElement("div");
     overlay.id = "shot-animation";
-    overlay.className = shot === 6 ? "shot-six" : "shot-four";
-    overlay.innerHTML = `<span>${shot}</span>`;
+    overlay.className = OUTCOME_CLASSES[outcome.result] || "shot-run";
+    const label = document.createElement("span");
+    label.textContent = outcome.out ? "OUT!" : (outcome.runs === 0 ? "•" : outcome.runs);
+    overlay.appendChild(label);
     document.body.appendChild(overlay);
 
     // Force reflow to ensure animation plays on mobile
@@ -33,29 +47,23 @@ function showShotAnimation(shot) {
 
 // Disable/enable buttons with countdown
 function setButtonsDisabled(disabled) {
-    const btn4 = document.getElementById("btn-four");
-    const btn6 = document.getElementById("btn-six");
+    const btn = document.getElementById("btn-play");
 
-    if (!btn4 || !btn6) return;
+    if (!btn) return;
 
-    btn4.disabled = disabled;
-    btn6.disabled = disabled;
+    btn.disabled = disabled;
 
     if (disabled) {
-        btn4.style.opacity = "0.5";
-        btn6.style.opacity = "0.5";
-        btn4.style.pointerEvents = "none";
-        btn6.style.pointerEvents = "none";
+        btn.style.opacity = "0.5";
+        btn.style.pointerEvents = "none";
     } else {
-        btn4.style.opacity = "1";
-        btn6.style.opacity = "1";
-        btn4.style.pointerEvents = "auto";
-        btn6.style.pointerEvents = "auto";
+        btn.style.opacity = "1";
+        btn.style.pointerEvents = "auto";
     }
 }
 
-// Hit shot API call
-function hitShot(shot) {
+// Hit shot API call - the server decides the runs
+function hitShot() {
     if (isButtonDisabled) {
         return;
     }
@@ -82,28 +90,164 @@ function hitShot(shot) {
     isButtonDisabled = true;
     setButtonsDisabled(true);
 
-    // Re-enable after cooldown
-    setTimeout(() => {
-        isButtonDisabled = false;
-        setButtonsDisabled(false);
-    }, COOLDOWN_SECONDS * 1000);
+    const joinCode = document.getElementById("joinCode").value.trim();
+    // The same key is sent on a retry so a hit that did reach the server
+    // is not played twice
+    const idempotencyKey = newIdempotencyKey();
 
-    fetch(`${API_BASE_URL}/hit`, {
-        method: "POST",
-        headers: { "Content-Type": "application/json" },
-        body: JSON.stringify({ name: name, rollNumber: rollNumber, shot })
+    ensureSession(rollNumber, joinCode)
+    .then(session => {
+        const headers = {
+            "Content-Type": "application/json",
+            "Idempotency-Key": idempotencyKey
+        };
+        if (session) {
+            headers["Authorization"] = `Bearer ${session.token}`;
+        }
+        const request = () => fetch(`${API_BASE_URL}/hit`, {
+            method: "POST",
+            headers: headers,
+            body: JSON.stringify({ name: name, rollNumber: rollNumber })
+        });
+        // Retry once if the network dropped the request or response
+        return request().catch(() => request());
+    })
+    .then(response => {
+        if (response.status === 401) {
+            localStorage.removeItem(SESSION_KEY);
+        }
+        startCooldown(cooldownFromHeaders(response));
+        return response.json();
     })
-    .then(response => response.json())
     .then(data => {
         if (data.error) {
             alert(data.error);
         } else {
             // Show animation on success
-            showShotAnimation(shot);
+            showShotAnimation(data.outcome);
+        }
+    })
+    .catch(error => {
+        console.error("Error:", error);
+        if (error.message) {
+            alert(error.message);
         }
-        fetchScoreboard(); // Update scoreboard after every shot
+        startCooldown(COOLDOWN_SECONDS);
+    });
+}
+
+// Random key identifying one press of the play button
+function newIdempotencyKey() {
+    if (window.crypto && crypto.randomUUID) {
+        return crypto.randomUUID();
+    }
+    return `${Date.now()}-${Math.random().toString(36).slice(2)}`;
+}
+
+// Resolve to the saved session for this roll number, logging in with the
+// join code first if there is none. Resolves to null when playing without
+// a join code.
+function ensureSession(rollNumber, joinCode) {
+    const saved = JSON.parse(localStorage.getItem(SESSION_KEY) || "null");
+    if (saved && saved.rollNumber === rollNumber && new Date(saved.expiresAt) > new Date()) {
+        return Promise.resolve(saved);
+    }
+    if (!joinCode) {
+        return Promise.resolve(null);
+    }
+
+    return fetch(`${API_BASE_URL}/login`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ rollNumber: rollNumber, code: joinCode })
     })
-    .catch(error => console.error("Error:", error));
+    .then(response => response.json())
+    .then(data => {
+        if (data.error) {
+            throw new Error(data.error);
+        }
+        localStorage.setItem(SESSION_KEY, JSON.stringify(data));
+        if (data.name) {
+            document.getElementById("name").value = data.name;
+        }
+        return data;
+    });
+}
+
+// Seconds to wait before the next hit, as told by the server's
+// Retry-After / X-RateLimit-* headers
+function cooldownFromHeaders(response) {
+    const retryAfter = response.headers.get("Retry-After");
+    if (retryAfter !== null) {
+        return Number(retryAfter);
+    }
+    const remaining = response.headers.get("X-RateLimit-Remaining");
+    const reset = response.headers.get("X-RateLimit-Reset");
+    if (remaining === null || reset === null) {
+        return COOLDOWN_SECONDS;
+    }
+    return Number(remaining) > 0 ? 0 : Number(reset);
+}
+
+// Re-enable the button after the cooldown
+function startCooldown(seconds) {
+    setTimeout(() => {
+        isButtonDisabled = false;
+        setButtonsDisabled(false);
+    }, seconds * 1000);
+}
+
+// Current leaderboard rows, kept in rank order
+let scoreboardRows = [];
+
+// Render the scoreboard table. Rows in `movedUp` get a highlight so
+// overtakes are visible on the projector.
+function renderScoreboard(movedUp = new Set()) {
+    const table = document.createElement("table");
+    table.className = "scoreboard-table";
+    table.innerHTML = "<thead><tr><th>Rank</th><th>Name</th><th>Roll Number</th><th>Score</th></tr></thead>";
+    const tbody = document.createElement("tbody");
+
+    if (scoreboardRows.length > 0) {
+        scoreboardRows.forEach(student => {
+            const row = document.createElement("tr");
+            if (movedUp.has(student.rollNumber)) {
+                row.className = "rank-up";
+            }
+            [student.rank, student.name || "-", student.rollNumber, `${student.score} Runs`].forEach(value => {
+                const cell = document.createElement("td");
+                cell.textContent = value;
+                row.appendChild(cell);
+            });
+            tbody.appendChild(row);
+        });
+    } else {
+        tbody.innerHTML = "<tr><td colspan='4'>No scores yet. Be the first to play!</td></tr>";
+    }
+
+    table.appendChild(tbody);
+    const container = document.getElementById("scoreboard");
+    container.innerHTML = "";
+    container.appendChild(table);
+}
+
+// Apply a diff pushed by the server and re-sort by rank
+function applyScoreboardDiff(diff) {
+    const byRoll = new Map(scoreboardRows.map(row => [row.rollNumber, row]));
+    const movedUp = new Set();
+
+    (diff.removed || []).forEach(rollNumber => byRoll.delete(rollNumber));
+    diff.changes.forEach(change => {
+        byRoll.set(change.rollNumber, change);
+        if (change.previousRank === 0 || change.rank < change.previousRank) {
+            movedUp.add(change.rollNumber);
+        }
+    });
+
+    scoreboardRows = Array.from(byRoll.values())
+        .filter(row => row.rank <= diff.total)
+        .sort((a, b) => a.rank - b.rank);
+    renderScoreboard(movedUp);
 }
 
 // Fetch scoreboard data
@@ -115,31 +259,68 @@ function fetchScoreboard() {
     })
         .then(response => response.json())
         .then(data => {
-            let scoreboardHTML = "<table class='scoreboard-table'>";
-            scoreboardHTML += "<thead><tr><th>Rank</th><th>Name</th><th>Roll Number</th><th>Score</th></tr></thead>";
-            scoreboardHTML += "<tbody>";
-
-            if (data && data.length > 0) {
-                data.forEach((student, index) => {
-                    scoreboardHTML += `<tr>
-                        <td>${index + 1}</td>
-                        <td>${student.name || '-'}</td>
-                        <td>${student.rollNumber}</td>
-                        <td>${student.score} Runs</td>
-                    </tr>`;
-                });
-            } else {
-                scoreboardHTML += "<tr><td colspan='4'>No scores yet. Be the first to play!</td></tr>";
+            scoreboardRows = Array.isArray(data) ? data : [];
+            renderScoreboard();
+        })
+        .catch(error => console.error("Error fetching scoreboard:", error));
+}
+
+// Subscribe to live scoreboard pushes, falling back to polling every 10
+// seconds on browsers without EventSource
+function subscribeScoreboard() {
+    if (!window.EventSource) {
+        fetchScoreboard();
+        setInterval(fetchScoreboard, 10000);
+        return;
+    }
+
+    const source = new EventSource(`${API_BASE_URL}/scoreboard/stream`);
+    source.addEventListener("snapshot", event => {
+        scoreboardRows = JSON.parse(event.data);
+        renderScoreboard();
+    });
+    source.addEventListener("diff", event => {
+        applyScoreboardDiff(JSON.parse(event.data));
+    });
+    source.onerror = () => console.error("Live scoreboard disconnected, retrying...");
+}
+
+// Fetch house standings; the section stays hidden unless team mode is on
+function fetchTeamScoreboard() {
+    fetch(`${API_BASE_URL}/teams/scoreboard`)
+        .then(response => response.json())
+        .then(teams => {
+            const section = document.getElementById("team-section");
+            if (!teams || teams.length === 0) {
+                section.style.display = "none";
+                return;
             }
 
-            scoreboardHTML += "</tbody></table>";
-            document.getElementById("scoreboard").innerHTML = scoreboardHTML;
+            const table = document.createElement("table");
+            table.className = "scoreboard-table";
+            table.innerHTML = "<thead><tr><th>Rank</th><th>House</th><th>Runs</th><th>Players</th><th>Avg</th></tr></thead>";
+            const tbody = document.createElement("tbody");
+            teams.forEach(team => {
+                const row = document.createElement("tr");
+                [team.rank, team.team, team.runs, team.players, team.average].forEach(value => {
+                    const cell = document.createElement("td");
+                    cell.textContent = value;
+                    row.appendChild(cell);
+                });
+                tbody.appendChild(row);
+            });
+            table.appendChild(tbody);
+
+            const container = document.getElementById("team-scoreboard");
+            container.innerHTML = "";
+            container.appendChild(table);
+            section.style.display = "block";
         })
-        .catch(error => console.error("Error fetching scoreboard:", error));
+        .catch(error => console.error("Error fetching house standings:", error));
 }
 
-// Load scoreboard on page load and update every 10 seconds
 window.onload = function () {
-    fetchScoreboard();
-    setInterval(fetchScoreboard, 10000);
+    subscribeScoreboard();
+    fetchTeamScoreboard();
+    setInterval(fetchTeamScoreboard, 10000);
 };
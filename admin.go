@@ -0,0 +1,558 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	ADMIN_SEARCH_DEFAULT_LIMIT = 50
+	ADMIN_SEARCH_MAX_LIMIT     = 500
+	ADMIN_AUDIT_DEFAULT_LIMIT  = 100
+	ADMIN_AUDIT_MAX_LIMIT      = 1000
+)
+
+// Admin key from ADMIN_KEY; empty means the admin routes are switched off
+var adminKey string
+
+func loadAdminConfig() {
+	adminKey = currentConfig().Auth.AdminKey
+	if adminKey == "" {
+		logger.Warn("ADMIN_KEY not set, admin routes disabled")
+	}
+}
+
+// adminActor checks the request's credentials: either
+// "Authorization: Bearer <ADMIN_KEY>" or basic auth with ADMIN_KEY as the
+// password. The basic auth user name is recorded in the audit log so
+// moderators can tell each other's actions apart.
+func adminActor(r *http.Request) (string, bool) {
+	if adminKey == "" {
+		return "", false
+	}
+	if user, password, ok := r.BasicAuth(); ok {
+		return user, subtle.ConstantTimeCompare([]byte(password), []byte(adminKey)) == 1
+	}
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	return "admin", subtle.ConstantTimeCompare([]byte(token), []byte(adminKey)) == 1
+}
+
+// requireAdmin only lets requests with valid admin credentials through
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			return // CORS preflight
+		}
+
+		if _, ok := adminActor(r); !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("WWW-Authenticate", `Basic realm="cricket admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Admin key required"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// auditedAction is an audit entry written before its action ran. finish
+// records how the action went.
+type auditedAction struct {
+	r     *http.Request
+	entry AuditEntry
+}
+
+// audit writes a pending entry before the action it describes is carried
+// out. If it cannot be written the action is refused, so nothing happens
+// off the record. On failure it writes the error response and returns
+// false.
+func audit(ctx context.Context, w http.ResponseWriter, r *http.Request, action, eventID, rollNumber, reason string, details map[string]interface{}) (*auditedAction, bool) {
+	actor, _ := adminActor(r)
+	entry := newAuditEntry(actor, action, eventID, rollNumber, reason, clientIP(r))
+	entry.Details = details
+	if err := auditLog.Append(ctx, entry); err != nil {
+		noteError(r.Context(), "Audit log", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Could not write the audit log, nothing was changed"})
+		return nil, false
+	}
+	return &auditedAction{r: r, entry: entry}, true
+}
+
+// finish marks the entry done, or failed with err. It has its own
+// deadline since the action may have used up the request's.
+func (a *auditedAction) finish(err error) {
+	outcome, message := AUDIT_DONE, ""
+	if err != nil {
+		outcome, message = AUDIT_FAILED, err.Error()
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(a.r.Context()), 10*time.Second)
+	defer cancel()
+	if err := auditLog.Finish(ctx, a.entry.ID, outcome, message); err != nil {
+		noteError(a.r.Context(), "Audit log", err)
+	}
+
+	entry := a.entry
+	contextLogger(a.r.Context()).Info("admin action", "actor", entry.Actor, "action", entry.Action, "event", entry.EventID,
+		"roll_number", entry.RollNumber, "reason", entry.Reason, "outcome", outcome)
+}
+
+// adminInput is the body shared by the admin actions
+type adminInput struct {
+	Reason  string `json:"reason"`
+	Runs    int    `json:"runs"`    // adjust
+	Name    string `json:"name"`    // rename
+	Confirm string `json:"confirm"` // reset: must repeat the event ID
+}
+
+// decodeAdminInput reads the body and checks a reason was given when one is
+// required. On failure it writes the error response and returns false.
+func decodeAdminInput(w http.ResponseWriter, r *http.Request, reasonRequired bool) (adminInput, bool) {
+	var input adminInput
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid input"})
+			return input, false
+		}
+	}
+	input.Reason = strings.TrimSpace(input.Reason)
+	if reasonRequired && input.Reason == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "A reason is required"})
+		return input, false
+	}
+	return input, true
+}
+
+// editableEvent resolves the {eventId} route variable like eventFromRequest
+// and refuses archived events, whose scores are final. On failure it writes
+// the error response and returns false.
+func editableEvent(w http.ResponseWriter, r *http.Request) (*eventRuntime, bool) {
+	ev, ok := eventFromRequest(w, r)
+	if !ok {
+		return nil, false
+	}
+	if ev.Event().Status(time.Now()) == "archived" {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "This event has ended; its scores can no longer be changed"})
+		return nil, false
+	}
+	return ev, true
+}
+
+// adminStudent resolves the {rollNumber} route variable to a student of the
+// event. On failure it writes the error response and returns nil.
+func adminStudent(ctx context.Context, w http.ResponseWriter, r *http.Request, ev *eventRuntime) *Student {
+	rollNumber := mux.Vars(r)["rollNumber"]
+	if !validateRollNumber(rollNumber) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": currentConfig().Players.RollNumberMessage})
+		return nil
+	}
+	// A player whose hits are still batched or queued is not in the store
+	// yet, and the store's row would miss them
+	if ev.hasUnstored() {
+		if err := settleHits(ctx); err != nil {
+			noteError(r.Context(), "Settling queued hits", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Queued hits could not be stored yet. Try again shortly."})
+			return nil
+		}
+	}
+	student, err := ev.store.GetStudent(ctx, rollNumber)
+	if errors.Is(err, ErrStudentNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Student not found"})
+		return nil
+	}
+	if err != nil {
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error fetching student", http.StatusInternalServerError)
+		return nil
+	}
+	return student
+}
+
+// searchStudents handles GET /admin/students?q=&limit=&offset=. q matches
+// roll numbers by prefix and names anywhere, ignoring case.
+func searchStudents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ev, ok := eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	values := r.URL.Query()
+	limit, err := queryInt(values, "limit", ADMIN_SEARCH_DEFAULT_LIMIT, ADMIN_SEARCH_MAX_LIMIT)
+	if err == nil && limit == 0 {
+		err = errors.New("limit must be at least 1")
+	}
+	offset, offsetErr := queryInt(values, "offset", 0, ev.leaderboard.Len())
+	if err == nil {
+		err = offsetErr
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	query := strings.ToLower(strings.TrimSpace(values.Get("q")))
+	matches := []RankedStudent{}
+	for _, row := range ev.leaderboard.Range(0, ev.leaderboard.Len()) {
+		if query == "" || strings.HasPrefix(row.RollNumber, query) || strings.Contains(strings.ToLower(row.Name), query) {
+			matches = append(matches, row)
+		}
+	}
+
+	page := []RankedStudent{}
+	if offset < len(matches) {
+		page = matches[offset:]
+		if len(page) > limit {
+			page = page[:limit]
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"total":   len(matches),
+		"entries": page,
+	})
+}
+
+// adjustScore handles POST /admin/students/{rollNumber}/adjust with
+// {"runs": n, "reason": "..."}. Runs may be negative.
+func adjustScore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ev, ok := editableEvent(w, r)
+	if !ok {
+		return
+	}
+	input, ok := decodeAdminInput(w, r, true)
+	if !ok {
+		return
+	}
+	if input.Runs == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "runs must be a non-zero number"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	student := adminStudent(ctx, w, r, ev)
+	if student == nil {
+		return
+	}
+	if student.Score+input.Runs < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Adjustment would make the score negative"})
+		return
+	}
+	details := map[string]interface{}{"runs": input.Runs, "scoreBefore": student.Score}
+	action, ok := audit(ctx, w, r, "adjust-score", ev.ID(), student.RollNumber, input.Reason, details)
+	if !ok {
+		return
+	}
+
+	correction := newCorrection(ev.ID(), BALL_KIND_ADJUSTMENT, student.RollNumber, input.Reason, r)
+	correction.Shot.Runs = input.Runs
+	if err := ballLog.Append(ctx, correction); err != nil {
+		action.finish(err)
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error recording adjustment", http.StatusInternalServerError)
+		return
+	}
+	updated, err := ev.store.AdjustScore(ctx, student.RollNumber, input.Runs)
+	if err != nil {
+		action.finish(err)
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error updating score", http.StatusInternalServerError)
+		return
+	}
+	shown := ev.change(*updated, func(student *Student) { student.Score += input.Runs })
+	action.finish(nil)
+
+	json.NewEncoder(w).Encode(shown)
+}
+
+// renameStudent handles POST /admin/students/{rollNumber}/rename with
+// {"name": "...", "reason": "..."}. The new name sticks in every event:
+// later hits cannot change it back.
+func renameStudent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ev, ok := editableEvent(w, r)
+	if !ok {
+		return
+	}
+	input, ok := decodeAdminInput(w, r, false)
+	if !ok {
+		return
+	}
+	name, err := cleanName(input.Name)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	input.Name = name
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	student := adminStudent(ctx, w, r, ev)
+	if student == nil {
+		return
+	}
+	record, err := moderation.Get(ctx, student.RollNumber)
+	if err != nil {
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error reading moderation record", http.StatusInternalServerError)
+		return
+	}
+	details := map[string]interface{}{"from": student.Name, "to": input.Name}
+	action, ok := audit(ctx, w, r, "rename", ev.ID(), student.RollNumber, input.Reason, details)
+	if !ok {
+		return
+	}
+
+	record.DisplayName = input.Name
+	record.UpdatedAt = time.Now()
+	if err := moderation.Save(ctx, *record); err != nil {
+		action.finish(err)
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error saving moderation record", http.StatusInternalServerError)
+		return
+	}
+	correction := newCorrection(ev.ID(), BALL_KIND_RENAME, student.RollNumber, input.Reason, r)
+	correction.Name = input.Name
+	if err := ballLog.Append(ctx, correction); err != nil {
+		action.finish(err)
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error recording rename", http.StatusInternalServerError)
+		return
+	}
+	updated, err := ev.store.Rename(ctx, student.RollNumber, input.Name)
+	if err != nil {
+		action.finish(err)
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error updating name", http.StatusInternalServerError)
+		return
+	}
+	shown := ev.change(*updated, func(student *Student) { student.Name = input.Name })
+	action.finish(nil)
+
+	json.NewEncoder(w).Encode(shown)
+}
+
+// deleteStudent handles DELETE /admin/students/{rollNumber}
+func deleteStudent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ev, ok := editableEvent(w, r)
+	if !ok {
+		return
+	}
+	input, ok := decodeAdminInput(w, r, true)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	student := adminStudent(ctx, w, r, ev)
+	if student == nil {
+		return
+	}
+	details := map[string]interface{}{"name": student.Name, "score": student.Score}
+	action, ok := audit(ctx, w, r, "delete-student", ev.ID(), student.RollNumber, input.Reason, details)
+	if !ok {
+		return
+	}
+
+	correction := newCorrection(ev.ID(), BALL_KIND_DELETE, student.RollNumber, input.Reason, r)
+	if err := ballLog.Append(ctx, correction); err != nil {
+		action.finish(err)
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error recording deletion", http.StatusInternalServerError)
+		return
+	}
+	if err := ev.store.Delete(ctx, student.RollNumber); err != nil && !errors.Is(err, ErrStudentNotFound) {
+		action.finish(err)
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error deleting student", http.StatusInternalServerError)
+		return
+	}
+	ev.remove(student.RollNumber)
+	action.finish(nil)
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Student deleted"})
+}
+
+// resetEvent handles POST /admin/reset with {"confirm": "<eventId>",
+// "reason": "..."} and wipes every score of the event
+func resetEvent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ev, ok := editableEvent(w, r)
+	if !ok {
+		return
+	}
+	input, ok := decodeAdminInput(w, r, true)
+	if !ok {
+		return
+	}
+	if input.Confirm != ev.ID() {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Set \"confirm\" to %q to reset this event", ev.ID())})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Minute)
+	defer cancel()
+
+	details := map[string]interface{}{"students": ev.leaderboard.Len()}
+	action, ok := audit(ctx, w, r, "reset-event", ev.ID(), "", input.Reason, details)
+	if !ok {
+		return
+	}
+
+	// Hits accepted before the reset must reach the store before it, or
+	// they would be written after the wipe and count again
+	if err := settleHits(ctx); err != nil {
+		action.finish(err)
+		noteError(r.Context(), "Settling queued hits", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Queued hits could not be stored yet, nothing was reset. Try again shortly."})
+		return
+	}
+	if err := ballLog.Append(ctx, newCorrection(ev.ID(), BALL_KIND_RESET, "", input.Reason, r)); err != nil {
+		action.finish(err)
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error recording reset", http.StatusInternalServerError)
+		return
+	}
+	if err := ev.store.ReplaceAll(ctx, nil); err != nil {
+		action.finish(err)
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error resetting scores", http.StatusInternalServerError)
+		return
+	}
+	action.finish(nil)
+	if err := ev.reload(ctx); err != nil {
+		noteError(r.Context(), "", err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"message": "Event reset"})
+}
+
+// banPlayer handles POST /admin/bans/{rollNumber} with {"reason": "..."}.
+// Bans apply to every event; existing scores stay until deleted.
+func banPlayer(w http.ResponseWriter, r *http.Request) {
+	setBan(w, r, true)
+}
+
+// unbanPlayer handles DELETE /admin/bans/{rollNumber}
+func unbanPlayer(w http.ResponseWriter, r *http.Request) {
+	setBan(w, r, false)
+}
+
+func setBan(w http.ResponseWriter, r *http.Request, banned bool) {
+	w.Header().Set("Content-Type", "application/json")
+
+	rollNumber := mux.Vars(r)["rollNumber"]
+	if !validateRollNumber(rollNumber) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": currentConfig().Players.RollNumberMessage})
+		return
+	}
+	input, ok := decodeAdminInput(w, r, banned)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	record, err := moderation.Get(ctx, rollNumber)
+	if err != nil {
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error reading moderation record", http.StatusInternalServerError)
+		return
+	}
+	action := "ban"
+	if !banned {
+		action = "unban"
+	}
+	audited, ok := audit(ctx, w, r, action, "", rollNumber, input.Reason, nil)
+	if !ok {
+		return
+	}
+
+	record.Banned = banned
+	record.BanReason = input.Reason
+	record.UpdatedAt = time.Now()
+	if err := moderation.Save(ctx, *record); err != nil {
+		audited.finish(err)
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error saving moderation record", http.StatusInternalServerError)
+		return
+	}
+	audited.finish(nil)
+	json.NewEncoder(w).Encode(record)
+}
+
+// listBans handles GET /admin/bans
+func listBans(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	records, err := moderation.Banned(ctx)
+	if err != nil {
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error listing bans", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(records)
+}
+
+// listAudit handles GET /admin/audit?limit=, newest first
+func listAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	limit, err := queryInt(r.URL.Query(), "limit", ADMIN_AUDIT_DEFAULT_LIMIT, ADMIN_AUDIT_MAX_LIMIT)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	entries, err := auditLog.Recent(ctx, limit)
+	if err != nil {
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error reading audit log", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminKey = "test-admin-key"
+
+// failingStore fails the writes a test switches on and passes everything
+// else to the real store
+type failingStore struct {
+	ScoreStore
+	failRecord, failAdjust bool
+}
+
+var errStoreDown = errors.New("store is down")
+
+func (s *failingStore) RecordShot(ctx context.Context, ball BallEvent) (*Student, error) {
+	if s.failRecord {
+		return nil, errStoreDown
+	}
+	return s.ScoreStore.RecordShot(ctx, ball)
+}
+
+func (s *failingStore) AdjustScore(ctx context.Context, rollNumber string, runs int) (*Student, error) {
+	if s.failAdjust {
+		return nil, errStoreDown
+	}
+	return s.ScoreStore.AdjustScore(ctx, rollNumber, runs)
+}
+
+func serveAdmin(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testAdminKey)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuditRecordsOutcome(t *testing.T) {
+	server := newTestServer(t, map[string]string{"ADMIN_KEY": testAdminKey})
+	if rec := serveJSON(server, "POST", "/hit", `{"rollNumber":"2021000001","name":"Asha"}`); rec.Code != http.StatusOK {
+		t.Fatalf("hit: %d %s", rec.Code, rec.Body.String())
+	}
+	ev, _ := getEvent(DEFAULT_EVENT_ID)
+	store := &failingStore{ScoreStore: ev.store}
+	ev.store = store
+
+	adjust := `{"runs": 4, "reason": "scorer missed a boundary"}`
+	if rec := serveAdmin(server, "POST", "/admin/students/2021000001/adjust", adjust); rec.Code != http.StatusOK {
+		t.Fatalf("adjust: %d %s", rec.Code, rec.Body.String())
+	}
+	store.failAdjust = true
+	if rec := serveAdmin(server, "POST", "/admin/students/2021000001/adjust", adjust); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("adjust with the store down: got %d, want 500", rec.Code)
+	}
+
+	entries, err := auditLog.Recent(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d audit entries, want 2", len(entries))
+	}
+	if failed := entries[0]; failed.Outcome != AUDIT_FAILED || failed.Error != errStoreDown.Error() {
+		t.Fatalf("failed adjustment recorded as %q (%q)", failed.Outcome, failed.Error)
+	}
+	if done := entries[1]; done.Outcome != AUDIT_DONE || done.Error != "" {
+		t.Fatalf("adjustment recorded as %q (%q)", done.Outcome, done.Error)
+	}
+}
+
+func TestAdminRoutesAreIPLimited(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"ADMIN_KEY":                   testAdminKey,
+		"RATE_LIMIT_ADMIN_BURST":      "2",
+		"RATE_LIMIT_ADMIN_PER_SECOND": "0.001",
+		"RATE_LIMIT_IP_BURST":         "1",
+		"RATE_LIMIT_IP_PER_SECOND":    "0.001",
+	})
+	for i := 0; i < 2; i++ {
+		if rec := serveJSON(server, "GET", "/admin/bans", ""); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("request %d without the key: got %d, want 401", i, rec.Code)
+		}
+	}
+	rec := serveAdmin(server, "GET", "/admin/bans", "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("third request: got %d, want 429 with Retry-After", rec.Code)
+	}
+
+	// Admin requests have their own bucket, so the device can still play
+	if rec := serveJSON(server, "POST", "/hit", `{"rollNumber":"2021000001","name":"Asha"}`); rec.Code != http.StatusOK {
+		t.Fatalf("hit after the admin limit: got %d, want 200", rec.Code)
+	}
+}
+
+// Hits queued in the journal while the store was down must not come back
+// once the store recovers after an event reset
+func TestResetSettlesJournalBacklog(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"ADMIN_KEY":           testAdminKey,
+		"HIT_JOURNAL":         "true",
+		"RATE_LIMIT_IP_BURST": "1000",
+	})
+	ev, _ := getEvent(DEFAULT_EVENT_ID)
+	store := &failingStore{ScoreStore: ev.store, failRecord: true}
+	ev.store = store
+
+	if rec := serveJSON(server, "POST", "/hit", `{"rollNumber":"2021000001","name":"Asha"}`); rec.Code != http.StatusOK {
+		t.Fatalf("hit: %d %s", rec.Code, rec.Body.String())
+	}
+	if backlog.Len() != 1 {
+		t.Fatalf("backlog has %d hits, want 1", backlog.Len())
+	}
+
+	store.failRecord = false
+	reset := `{"confirm": "default", "reason": "new season"}`
+	if rec := serveAdmin(server, "POST", "/admin/reset", reset); rec.Code != http.StatusOK {
+		t.Fatalf("reset: %d %s", rec.Code, rec.Body.String())
+	}
+	if err := drainBacklog(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ev.store.GetStudent(context.Background(), "2021000001"); !errors.Is(err, ErrStudentNotFound) {
+		t.Fatalf("pre-reset hit is back in the store: %v", err)
+	}
+	if ev.leaderboard.Len() != 0 {
+		t.Fatalf("board has %d students after the reset, want 0", ev.leaderboard.Len())
+	}
+}
+
+// Scores of an archived event are final: every admin change is refused
+// before anything is written
+func TestArchivedEventRefusesChanges(t *testing.T) {
+	server := newTestServer(t, map[string]string{"ADMIN_KEY": testAdminKey})
+	ctx := context.Background()
+	spring := Event{ID: "spring", Name: "Spring", StartsAt: time.Now().Add(-time.Hour)}
+	if err := eventCatalog.Save(ctx, spring); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadEvents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rec := serveJSON(server, "POST", "/events/spring/hit", `{"rollNumber":"2021000001","name":"Asha"}`); rec.Code != http.StatusOK {
+		t.Fatalf("hit: %d %s", rec.Code, rec.Body.String())
+	}
+	spring.Archived = true
+	if err := eventCatalog.Save(ctx, spring); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadEvents(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ev, _ := getEvent("spring")
+	before, _ := ev.leaderboard.Get("2021000001")
+
+	tests := []struct {
+		action, method, path, body string
+	}{
+		{"adjust", "POST", "/admin/events/spring/students/2021000001/adjust", `{"runs": 4, "reason": "missed boundary"}`},
+		{"rename", "POST", "/admin/events/spring/students/2021000001/rename", `{"name": "Asha K"}`},
+		{"delete", "DELETE", "/admin/events/spring/students/2021000001", `{"reason": "duplicate"}`},
+		{"reset", "POST", "/admin/events/spring/reset", `{"confirm": "spring", "reason": "test run"}`},
+	}
+	for _, tt := range tests {
+		if rec := serveAdmin(server, tt.method, tt.path, tt.body); rec.Code != http.StatusConflict {
+			t.Errorf("%s: got %d, want 409", tt.action, rec.Code)
+		}
+	}
+
+	if after, ok := ev.leaderboard.Get("2021000001"); !ok || !reflect.DeepEqual(after, before) {
+		t.Errorf("board changed from %+v to %+v", before, after)
+	}
+	if entries, err := auditLog.Recent(ctx, 10); err != nil || len(entries) != 0 {
+		t.Errorf("got %d audit entries (%v), want none", len(entries), err)
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	JOIN_CODE_LENGTH    = 8
+	JOIN_CODE_ALPHABET  = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // No 0/O or 1/I
+	SESSION_COOKIE_NAME = "cricket_session"
+)
+
+var (
+	ErrNoSession     = errors.New("not logged in")
+	ErrBadSession    = errors.New("session is invalid or has expired")
+	ErrNoJoinCode    = errors.New("no join code issued")
+	ErrWrongJoinCode = errors.New("invalid roll number or join code")
+)
+
+var (
+	// "off" takes the roll number from the /hit body as before, "optional"
+	// uses a session when one is sent and "required" rejects hits without one
+	authMode      string
+	sessionSecret []byte
+	sessionTTL    time.Duration
+	joinCodes     JoinCodeStore
+)
+
+// loadAuthConfig takes AUTH_MODE, SESSION_SECRET and SESSION_TTL_HOURS
+// from the config. Validate insists on a SESSION_SECRET unless auth is
+// off; with auth off nobody checks sessions, so a random one will do.
+func loadAuthConfig() {
+	cfg := currentConfig().Auth
+	authMode = cfg.Mode
+
+	if cfg.SessionSecret != "" {
+		sessionSecret = []byte(cfg.SessionSecret)
+	} else {
+		sessionSecret = make([]byte, 32)
+		rand.Read(sessionSecret)
+	}
+	sessionTTL = time.Duration(cfg.SessionTTLHours) * time.Hour
+
+	logger.Info("player authentication", "mode", authMode)
+}
+
+// JoinCodeStore keeps one hashed join code per roll number. Codes are
+// handed out on paper before the game and work until they are reissued.
+type JoinCodeStore interface {
+	Save(ctx context.Context, codes []JoinCode) error
+	// Get returns the code for a roll number or ErrNoJoinCode
+	Get(ctx context.Context, rollNumber string) (*JoinCode, error)
+}
+
+type JoinCode struct {
+	RollNumber string    `bson:"_id"`
+	CodeHash   string    `bson:"codeHash"`
+	IssuedAt   time.Time `bson:"issuedAt"`
+}
+
+// IssuedJoinCode is a freshly made code, the only time it is in plain text
+type IssuedJoinCode struct {
+	RollNumber string `json:"rollNumber"`
+	Name       string `json:"name,omitempty"`
+	Code       string `json:"code"`
+}
+
+// newJoinCodeStore opens the hashed join codes, keyed by roll number
+func newJoinCodeStore() JoinCodeStore {
+	if codes := openCollection("join_codes"); codes != nil {
+		return &mongoJoinCodeStore{collection: codes}
+	}
+	return &memoryJoinCodeStore{codes: make(map[string]JoinCode)}
+}
+
+func generateJoinCode() string {
+	buf := make([]byte, JOIN_CODE_LENGTH)
+	rand.Read(buf)
+	for i := range buf {
+		buf[i] = JOIN_CODE_ALPHABET[int(buf[i])%len(JOIN_CODE_ALPHABET)]
+	}
+	return string(buf)
+}
+
+// hashJoinCode ignores case, spaces and dashes so "abcd-efgh" matches
+// "ABCDEFGH"
+func hashJoinCode(rollNumber, code string) string {
+	code = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	sum := sha256.Sum256([]byte(rollNumber + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueJoinCodes makes new codes for the given roll numbers, or for
+// everyone on the roster when none are given. Old codes stop working, and
+// so do the sessions logged in with them.
+func issueJoinCodes(ctx context.Context, rollNumbers []string) ([]IssuedJoinCode, error) {
+	entries, err := roster.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		names[entry.RollNumber] = entry.Name
+	}
+	if len(rollNumbers) == 0 {
+		for _, entry := range entries {
+			rollNumbers = append(rollNumbers, entry.RollNumber)
+		}
+	}
+	if len(rollNumbers) == 0 {
+		return nil, errors.New("no roll numbers given and the roster is empty")
+	}
+
+	now := time.Now()
+	issued := make([]IssuedJoinCode, 0, len(rollNumbers))
+	codes := make([]JoinCode, 0, len(rollNumbers))
+	for _, rollNumber := range rollNumbers {
+		if !validateRollNumber(rollNumber) {
+			return nil, fmt.Errorf("roll number %q: %s", rollNumber, currentConfig().Players.RollNumberMessage)
+		}
+		code := generateJoinCode()
+		issued = append(issued, IssuedJoinCode{RollNumber: rollNumber, Name: names[rollNumber], Code: code})
+		codes = append(codes, JoinCode{RollNumber: rollNumber, CodeHash: hashJoinCode(rollNumber, code), IssuedAt: now})
+	}
+	if err := joinCodes.Save(ctx, codes); err != nil {
+		return nil, err
+	}
+	return issued, nil
+}
+
+// checkJoinCode returns the roll number's current join code, or
+// ErrWrongJoinCode unless code is that code
+func checkJoinCode(ctx context.Context, rollNumber, code string) (*JoinCode, error) {
+	stored, err := joinCodes.Get(ctx, rollNumber)
+	if err == ErrNoJoinCode {
+		return nil, ErrWrongJoinCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(stored.CodeHash), []byte(hashJoinCode(rollNumber, code))) != 1 {
+		return nil, ErrWrongJoinCode
+	}
+	return stored, nil
+}
+
+// ---------------------------------------------------------------------------
+// Session tokens
+// ---------------------------------------------------------------------------
+
+// A session token is base64url(claims) + "." + base64url(HMAC-SHA256 of the
+// first part). Nothing is stored server side; the claims name the join code
+// the player logged in with, so reissuing the code ends the session.
+type sessionClaims struct {
+	RollNumber string `json:"r"`
+	Code       string `json:"c"` // codeTag of the join code
+	ExpiresAt  int64  `json:"exp"`
+}
+
+func signSession(code JoinCode, now time.Time) (string, time.Time) {
+	expiresAt := now.Add(sessionTTL)
+	claims, _ := json.Marshal(sessionClaims{RollNumber: code.RollNumber, Code: codeTag(code), ExpiresAt: expiresAt.Unix()})
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + sessionSignature(payload), expiresAt
+}
+
+func sessionSignature(payload string) string {
+	mac := hmac.New(sha256.New, sessionSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// codeTag tells join codes apart without giving away anything that helps
+// guess them, since token claims are only encoded
+func codeTag(code JoinCode) string {
+	return sessionSignature("code:" + code.CodeHash)[:16]
+}
+
+// verifySession checks a token's signature and expiry and returns its
+// claims
+func verifySession(token string, now time.Time) (sessionClaims, error) {
+	var claims sessionClaims
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(sessionSignature(payload))) {
+		return claims, ErrBadSession
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return claims, ErrBadSession
+	}
+	if err := json.Unmarshal(raw, &claims); err != nil || now.Unix() >= claims.ExpiresAt {
+		return sessionClaims{}, ErrBadSession
+	}
+	return claims, nil
+}
+
+// sessionFromRequest reads the token from "Authorization: Bearer" or the
+// session cookie and returns the roll number it was issued to, as long as
+// the join code it was issued for has not been reissued since
+func sessionFromRequest(r *http.Request) (string, error) {
+	token := ""
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	} else if cookie, err := r.Cookie(SESSION_COOKIE_NAME); err == nil {
+		token = cookie.Value
+	}
+	if token == "" {
+		return "", ErrNoSession
+	}
+	claims, err := verifySession(token, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), dbTimeout())
+	defer cancel()
+	current, err := joinCodes.Get(ctx, claims.RollNumber)
+	if err == ErrNoJoinCode {
+		return "", ErrBadSession
+	}
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(codeTag(*current)), []byte(claims.Code)) {
+		return "", ErrBadSession
+	}
+	return claims.RollNumber, nil
+}
+
+// authenticateHit returns the roll number a hit is played as. With auth on,
+// a logged-in player's session decides and the body's roll number is
+// ignored.
+func authenticateHit(r *http.Request, bodyRollNumber string) (string, error) {
+	switch authMode {
+	case "optional", "required":
+		rollNumber, err := sessionFromRequest(r)
+		if err == ErrNoSession && authMode == "optional" {
+			return bodyRollNumber, nil
+		}
+		return rollNumber, err
+	}
+	return bodyRollNumber, nil
+}
+
+// ---------------------------------------------------------------------------
+// Handlers
+// ---------------------------------------------------------------------------
+
+// login handles POST /login with a roll number and join code, answering with
+// a session token (also set as an HttpOnly cookie)
+func login(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var input struct {
+		RollNumber string `json:"rollNumber"`
+		Code       string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	noteRoll(r.Context(), input.RollNumber)
+	if !validateRollNumber(input.RollNumber) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": currentConfig().Players.RollNumberMessage})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), dbTimeout())
+	defer cancel()
+
+	// Guessing codes costs device rate-limit tokens like hits do
+	if decision, err := ipLimiter.TryAcquire(ctx, clientIP(r)); err != nil {
+		noteError(r.Context(), "Rate limiter", err)
+	} else if !decision.Allowed {
+		rateLimitRejections.Inc("login")
+		setRateLimitHeaders(w, decision)
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Too many attempts. Please slow down."})
+		return
+	}
+
+	// Every attempt takes a token from the roll number's failure bucket and
+	// only a wrong code keeps it, so changing device doesn't buy more guesses
+	failures, err := loginLimiter.TryAcquire(ctx, input.RollNumber)
+	if err != nil {
+		noteError(r.Context(), "Rate limiter", err)
+	} else if !failures.Allowed {
+		rateLimitRejections.Inc("login_failures")
+		setRateLimitHeaders(w, failures)
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Too many wrong codes for this roll number. Please try again later."})
+		return
+	}
+	releaseFailure := func() {
+		if failures.Limit > 0 {
+			if err := loginLimiter.Release(ctx, input.RollNumber); err != nil {
+				noteError(r.Context(), "Rate limiter release", err)
+			}
+		}
+	}
+
+	code, err := checkJoinCode(ctx, input.RollNumber, input.Code)
+	if err == ErrWrongJoinCode {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid roll number or join code"})
+		return
+	} else if err != nil {
+		releaseFailure()
+		noteError(r.Context(), "Login", err)
+		http.Error(w, "Error checking join code", http.StatusInternalServerError)
+		return
+	}
+	releaseFailure()
+
+	name := ""
+	if entry, err := roster.Get(ctx, input.RollNumber); err == nil {
+		name = entry.Name
+	}
+
+	token, expiresAt := signSession(*code, time.Now())
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE_NAME,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"rollNumber": input.RollNumber,
+		"name":       name,
+		"expiresAt":  expiresAt,
+	})
+}
+
+// logout clears the session cookie. Bearer tokens simply expire.
+func logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: SESSION_COOKIE_NAME, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// issueJoinCodesHandler handles POST /admin/join-codes with an optional
+// {"rollNumbers": [...]} body. The codes are only ever shown in this
+// response.
+func issueJoinCodesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var input struct {
+		RollNumbers []string `json:"rollNumbers"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	details := map[string]interface{}{"rollNumbers": input.RollNumbers}
+	action, ok := audit(ctx, w, r, "issue-join-codes", "", "", "", details)
+	if !ok {
+		return
+	}
+	issued, err := issueJoinCodes(ctx, input.RollNumbers)
+	action.finish(err)
+	if err != nil {
+		noteError(r.Context(), "Issuing join codes", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	contextLogger(r.Context()).Info("issued join codes", "count", len(issued))
+	json.NewEncoder(w).Encode(issued)
+}
+
+// issueJoinCodesCommand runs `cricket issue-join-codes [rollNumber...]` and
+// prints the codes as CSV, ready for mail merge
+func issueJoinCodesCommand(args []string) {
+	initBackend()
+	roster = newRoster()
+	joinCodes = newJoinCodeStore()
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	issued, err := issueJoinCodes(ctx, args)
+	if err != nil {
+		fmt.Println("Issuing join codes:", err.Error())
+		os.Exit(1)
+	}
+
+	out := csv.NewWriter(os.Stdout)
+	out.Write([]string{"rollNumber", "name", "code"})
+	for _, code := range issued {
+		out.Write([]string{code.RollNumber, code.Name, code.Code})
+	}
+	out.Flush()
+}
+
+// ---------------------------------------------------------------------------
+// MongoDB join codes
+// ---------------------------------------------------------------------------
+
+type mongoJoinCodeStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoJoinCodeStore) Save(ctx context.Context, codes []JoinCode) error {
+	models := make([]mongo.WriteModel, 0, len(codes))
+	for _, code := range codes {
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": code.RollNumber}).
+			SetReplacement(code).
+			SetUpsert(true))
+	}
+	_, err := s.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func (s *mongoJoinCodeStore) Get(ctx context.Context, rollNumber string) (*JoinCode, error) {
+	var code JoinCode
+	err := s.collection.FindOne(ctx, bson.M{"_id": rollNumber}).Decode(&code)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNoJoinCode
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+// ---------------------------------------------------------------------------
+// In-memory join codes
+// ---------------------------------------------------------------------------
+
+type memoryJoinCodeStore struct {
+	mu    sync.RWMutex
+	codes map[string]JoinCode
+}
+
+func (s *memoryJoinCodeStore) Save(ctx context.Context, codes []JoinCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, code := range codes {
+		s.codes[code.RollNumber] = code
+	}
+	return nil
+}
+
+func (s *memoryJoinCodeStore) Get(ctx context.Context, rollNumber string) (*JoinCode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	code, exists := s.codes[rollNumber]
+	if !exists {
+		return nil, ErrNoJoinCode
+	}
+	return &code, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSessionSecret = "0123456789abcdef0123456789abcdef"
+
+func TestSessionTokens(t *testing.T) {
+	sessionSecret, sessionTTL = []byte(testSessionSecret), time.Hour
+	now := time.Now()
+	code := JoinCode{RollNumber: "2021000001", CodeHash: hashJoinCode("2021000001", "ABCDEFGH"), IssuedAt: now}
+	token, expiresAt := signSession(code, now)
+	if !expiresAt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("expires at %v, want an hour from now", expiresAt)
+	}
+
+	if claims, err := verifySession(token, now); err != nil || claims.RollNumber != "2021000001" || claims.Code != codeTag(code) {
+		t.Fatalf("fresh token: got %+v, %v", claims, err)
+	}
+	if _, err := verifySession(token, now.Add(time.Hour)); err != ErrBadSession {
+		t.Fatalf("expired token: got %v, want ErrBadSession", err)
+	}
+
+	payload, signature, _ := strings.Cut(token, ".")
+	flipped := []byte(signature)
+	flipped[0] ^= 1
+	forged := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"r":"2021000002","exp":%d}`, expiresAt.Unix())))
+	tampered := map[string]string{
+		"other player's claims": forged + "." + signature,
+		"extended expiry":       base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"r":"2021000001","exp":%d}`, expiresAt.Add(24*time.Hour).Unix()))) + "." + signature,
+		"flipped signature":     payload + "." + string(flipped),
+		"no signature":          payload,
+		"empty signature":       payload + ".",
+		"empty":                 "",
+	}
+	for name, bad := range tampered {
+		if _, err := verifySession(bad, now); err != ErrBadSession {
+			t.Errorf("%s: got %v, want ErrBadSession", name, err)
+		}
+	}
+
+	// A token signed with another secret is refused too
+	sessionSecret = []byte(strings.Repeat("x", 32))
+	if _, err := verifySession(token, now); err != ErrBadSession {
+		t.Fatalf("other secret: got %v, want ErrBadSession", err)
+	}
+}
+
+func TestSessionSecretRequiredWithAuth(t *testing.T) {
+	t.Setenv("STORE_BACKEND", "memory")
+	t.Setenv("AUTH_MODE", "required")
+	if _, err := loadConfig(configSource{}); err == nil || !strings.Contains(err.Error(), "SESSION_SECRET is required") {
+		t.Fatalf("got %v, want SESSION_SECRET is required", err)
+	}
+
+	t.Setenv("SESSION_SECRET", testSessionSecret)
+	if _, err := loadConfig(configSource{}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("AUTH_MODE", "off")
+	t.Setenv("SESSION_SECRET", "")
+	if _, err := loadConfig(configSource{}); err != nil {
+		t.Fatalf("auth off needs no secret, got %v", err)
+	}
+}
+
+// Wrong codes for one roll number are limited however many devices they
+// come from, and right codes don't count against the limit
+func TestLoginFailureLimitPerRoll(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"AUTH_MODE":                      "optional",
+		"SESSION_SECRET":                 testSessionSecret,
+		"RATE_LIMIT_IP_BURST":            "1000",
+		"RATE_LIMIT_LOGIN_FAILURE_BURST": "3",
+	})
+	issued, err := issueJoinCodes(context.Background(), []string{"2021000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := fmt.Sprintf(`{"rollNumber":"2021000001","code":%q}`, issued[0].Code)
+	wrong := `{"rollNumber":"2021000001","code":"WRONGONE"}`
+
+	for i := 0; i < 5; i++ {
+		if rec := serveJSON(server, "POST", "/login", good); rec.Code != http.StatusOK {
+			t.Fatalf("login %d with the right code: %d %s", i, rec.Code, rec.Body.String())
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if rec := serveJSON(server, "POST", "/login", wrong); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: got %d, want 401", i, rec.Code)
+		}
+	}
+	rec := serveJSON(server, "POST", "/login", good)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("after 3 wrong codes: got %d, want 429 with Retry-After", rec.Code)
+	}
+
+	// Other players are not affected
+	other, _ := issueJoinCodes(context.Background(), []string{"2021000002"})
+	if rec := serveJSON(server, "POST", "/login", fmt.Sprintf(`{"rollNumber":"2021000002","code":%q}`, other[0].Code)); rec.Code != http.StatusOK {
+		t.Fatalf("another roll number: got %d", rec.Code)
+	}
+}
+
+// Reissuing a player's join code logs out every device that used the old
+// one; other players stay logged in
+func TestReissuedCodeEndsSessions(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"AUTH_MODE":             "required",
+		"SESSION_SECRET":        testSessionSecret,
+		"RATE_LIMIT_IP_BURST":   "1000",
+		"RATE_LIMIT_ROLL_BURST": "1000",
+	})
+	ctx := context.Background()
+	loginAs := func(code IssuedJoinCode) string {
+		t.Helper()
+		rec := serveJSON(server, "POST", "/login", fmt.Sprintf(`{"rollNumber":%q,"code":%q}`, code.RollNumber, code.Code))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login as %s: %d %s", code.RollNumber, rec.Code, rec.Body.String())
+		}
+		var body struct {
+			Token string `json:"token"`
+		}
+		json.NewDecoder(rec.Body).Decode(&body)
+		return body.Token
+	}
+	hitWith := func(token string) int {
+		req := httptest.NewRequest("POST", "/hit", strings.NewReader(`{"name":"Asha"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	issued, err := issueJoinCodes(ctx, []string{"2021000001", "2021000002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, otherToken := loginAs(issued[0]), loginAs(issued[1])
+	reissued, err := issueJoinCodes(ctx, []string{"2021000001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken := loginAs(reissued[0])
+
+	tests := []struct {
+		session string
+		token   string
+		want    int
+	}{
+		{"logged in with the old code", oldToken, http.StatusUnauthorized},
+		{"logged in with the new code", newToken, http.StatusOK},
+		{"another player", otherToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := hitWith(tt.token); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.session, got, tt.want)
+		}
+	}
+}
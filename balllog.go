@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Kinds of ball log entry besides a plain hit. Moderator corrections are
+// logged too so a rebuild ends up with the same totals.
+const (
+	BALL_KIND_ADJUSTMENT = "adjustment" // Shot.Runs is added to the score, no ball is counted
+	BALL_KIND_RENAME     = "rename"     // Name replaces the display name
+	BALL_KIND_DELETE     = "delete"     // The student is removed
+	BALL_KIND_RESET      = "reset"      // Every student of the event is removed
+)
+
+// BallEvent is one accepted hit. Events are only ever appended, never
+// edited, so the students totals can always be rebuilt from them.
+type BallEvent struct {
+	ID         string      `json:"id" bson:"_id"`
+	EventID    string      `json:"eventId" bson:"eventId"`
+	Kind       string      `json:"kind,omitempty" bson:"kind,omitempty"` // Empty for a hit
+	RollNumber string      `json:"rollNumber" bson:"rollNumber"`
+	Name       string      `json:"name" bson:"name"` // Name sent with this hit
+	Team       string      `json:"team,omitempty" bson:"team,omitempty"`
+	Shot       ShotOutcome `json:"shot" bson:"shot"`
+	Reason     string      `json:"reason,omitempty" bson:"reason,omitempty"` // Why a moderator made a correction
+	Timestamp  time.Time   `json:"timestamp" bson:"timestamp"`
+	ClientIP   string      `json:"clientIp" bson:"clientIp"`
+	RequestID  string      `json:"requestId" bson:"requestId"`
+}
+
+// BallLog is the append-only ball-by-ball history
+type BallLog interface {
+	// Append stores a new event
+	Append(ctx context.Context, event BallEvent) error
+	// AppendMany stores several events. Events already stored are skipped, so
+	// a retried batch is harmless.
+	AppendMany(ctx context.Context, events []BallEvent) error
+	// Replay calls fn for every ball of one event in the order they were
+	// recorded
+	Replay(ctx context.Context, eventID string, fn func(BallEvent) error) error
+}
+
+// newBallEvent stamps a fresh event ID and timestamp for an accepted hit
+func newBallEvent(eventID, rollNumber, name, team string, shot ShotOutcome, clientIP, requestID string) BallEvent {
+	return BallEvent{
+		ID:         primitive.NewObjectID().Hex(),
+		EventID:    eventID,
+		RollNumber: rollNumber,
+		Name:       name,
+		Team:       team,
+		Shot:       shot,
+		Timestamp:  time.Now(),
+		ClientIP:   clientIP,
+		RequestID:  requestID,
+	}
+}
+
+// newCorrection stamps a moderator correction of the given kind
+func newCorrection(eventID, kind, rollNumber, reason string, r *http.Request) BallEvent {
+	return BallEvent{
+		ID:         primitive.NewObjectID().Hex(),
+		EventID:    eventID,
+		Kind:       kind,
+		RollNumber: rollNumber,
+		Reason:     reason,
+		Timestamp:  time.Now(),
+		ClientIP:   clientIP(r),
+		RequestID:  requestID(r),
+	}
+}
+
+// newBallLog opens the ball history, indexed for per-student history and
+// ordered per-event replay
+func newBallLog() BallLog {
+	events := openCollection("ball_events",
+		mongo.IndexModel{Keys: bson.D{{Key: "rollNumber", Value: 1}, {Key: "timestamp", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "eventId", Value: 1}, {Key: "timestamp", Value: 1}}},
+	)
+	if events != nil {
+		return &mongoBallLog{collection: events}
+	}
+	return &memoryBallLog{ids: make(map[string]bool)}
+}
+
+// ---------------------------------------------------------------------------
+// MongoDB ball log
+// ---------------------------------------------------------------------------
+
+type mongoBallLog struct {
+	collection *mongo.Collection
+}
+
+func (l *mongoBallLog) Append(ctx context.Context, event BallEvent) error {
+	_, err := l.collection.InsertOne(ctx, event)
+	return err
+}
+
+func (l *mongoBallLog) AppendMany(ctx context.Context, events []BallEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(events))
+	for i := range events {
+		docs[i] = events[i]
+	}
+
+	_, err := l.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		for _, writeErr := range bulkErr.WriteErrors {
+			if writeErr.Code != 11000 {
+				return err
+			}
+		}
+		return nil // Only duplicates, i.e. a replayed batch
+	}
+	return err
+}
+
+func (l *mongoBallLog) Replay(ctx context.Context, eventID string, fn func(BallEvent) error) error {
+	filter := bson.M{"eventId": eventID}
+	if eventID == DEFAULT_EVENT_ID {
+		// Balls logged before events existed have no eventId
+		filter = bson.M{"eventId": bson.M{"$in": bson.A{eventID, nil}}}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := l.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var event BallEvent
+		if err := cursor.Decode(&event); err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// ---------------------------------------------------------------------------
+// In-memory ball log
+// ---------------------------------------------------------------------------
+
+type memoryBallLog struct {
+	mu     sync.RWMutex
+	events []BallEvent
+	ids    map[string]bool
+}
+
+func (l *memoryBallLog) Append(ctx context.Context, event BallEvent) error {
+	l.mu.Lock()
+	l.events = append(l.events, event)
+	l.ids[event.ID] = true
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *memoryBallLog) AppendMany(ctx context.Context, events []BallEvent) error {
+	l.mu.Lock()
+	for _, event := range events {
+		if !l.ids[event.ID] {
+			l.events = append(l.events, event)
+			l.ids[event.ID] = true
+		}
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *memoryBallLog) Replay(ctx context.Context, eventID string, fn func(BallEvent) error) error {
+	l.mu.RLock()
+	events := make([]BallEvent, len(l.events))
+	copy(events, l.events)
+	l.mu.RUnlock()
+
+	for _, event := range events {
+		if event.EventID != eventID {
+			continue
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// Rebuild
+// ---------------------------------------------------------------------------
+
+// totalsFromBallLog folds the whole log into per-student totals. The latest
+// event decides the display name, lastPlayed and lastOutcome. Each total
+// carries its balls from the last APPLIED_BALLS_RETENTION, so a batch
+// replayed after a rebuild is skipped.
+func totalsFromBallLog(ctx context.Context, events BallLog, eventID string) ([]Student, error) {
+	totals := make(map[string]*Student)
+	err := events.Replay(ctx, eventID, func(event BallEvent) error {
+		student, exists := totals[event.RollNumber]
+		switch event.Kind {
+		case BALL_KIND_RESET:
+			totals = make(map[string]*Student)
+			return nil
+		case BALL_KIND_DELETE:
+			delete(totals, event.RollNumber)
+			return nil
+		case BALL_KIND_ADJUSTMENT:
+			if exists {
+				student.Score += event.Shot.Runs
+			}
+			return nil
+		case BALL_KIND_RENAME:
+			if exists {
+				student.Name = event.Name
+			}
+			return nil
+		}
+
+		if !exists {
+			student = &Student{RollNumber: event.RollNumber, Team: event.Team}
+			totals[event.RollNumber] = student
+		}
+		student.applyOutcome(event.Shot)
+		student.Name = event.Name
+		student.LastPlayed = event.Timestamp
+		if time.Since(event.Timestamp) < APPLIED_BALLS_RETENTION {
+			student.AppliedBalls = append(student.AppliedBalls, appliedBall{ID: event.ID, At: event.Timestamp})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	students := make([]Student, 0, len(totals))
+	for _, student := range totals {
+		students = append(students, *student)
+	}
+	sort.Slice(students, func(i, j int) bool {
+		return students[i].RollNumber < students[j].RollNumber
+	})
+	return students, nil
+}
+
+// rebuildScores replaces every student total of one event with the sum of
+// its ball log. Run as `cricket rebuild-scores [eventId]` after correcting or
+// removing balls.
+func rebuildScores(eventID string) {
+	initBackend()
+	store := newScoreStore(eventID)
+	ballLog = newBallLog()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	students, err := rebuildEvent(ctx, store, ballLog, eventID)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("Rebuilt %d students of event %q from the ball log\n", len(students), eventID)
+}
+
+// rebuildEvent writes the totals of one event's ball log over its store
+// and returns them
+func rebuildEvent(ctx context.Context, store ScoreStore, events BallLog, eventID string) ([]Student, error) {
+	students, err := totalsFromBallLog(ctx, events, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("reading ball log: %w", err)
+	}
+	if err := store.ReplaceAll(ctx, students); err != nil {
+		return nil, fmt.Errorf("writing students: %w", err)
+	}
+	return students, nil
+}
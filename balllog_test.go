@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testCorrection is a moderator correction as newCorrection logs it
+func testCorrection(kind, roll string, runs int, name string) BallEvent {
+	return BallEvent{
+		ID:         fmt.Sprintf("%s-%s-%d", kind, roll, runs),
+		EventID:    DEFAULT_EVENT_ID,
+		Kind:       kind,
+		RollNumber: roll,
+		Name:       name,
+		Shot:       ShotOutcome{Runs: runs},
+	}
+}
+
+func TestTotalsFromBallLog(t *testing.T) {
+	const asha, ravi = "2021000001", "2021000002"
+	otherEvent := testBall(asha, 99, 6)
+	otherEvent.EventID = "spring"
+
+	// total is what a student should end up with
+	type total struct {
+		name         string
+		score, balls int
+		fours, sixes int
+	}
+	tests := []struct {
+		name  string
+		balls []BallEvent
+		want  map[string]total
+	}{
+		{
+			"hits add up",
+			[]BallEvent{testBall(asha, 1, 4), testBall(asha, 2, 6), testBall(ravi, 1, 1)},
+			map[string]total{asha: {"Asha", 10, 2, 1, 1}, ravi: {"Asha", 1, 1, 0, 0}},
+		},
+		{
+			"adjustment moves the score but not the balls",
+			[]BallEvent{testBall(asha, 1, 4), testCorrection(BALL_KIND_ADJUSTMENT, asha, -3, "")},
+			map[string]total{asha: {"Asha", 1, 1, 1, 0}},
+		},
+		{
+			"adjustment of a player with no hits is dropped",
+			[]BallEvent{testCorrection(BALL_KIND_ADJUSTMENT, ravi, 5, ""), testBall(asha, 1, 2)},
+			map[string]total{asha: {"Asha", 2, 1, 0, 0}},
+		},
+		{
+			"rename replaces the name",
+			[]BallEvent{testBall(asha, 1, 2), testCorrection(BALL_KIND_RENAME, asha, 0, "Asha K")},
+			map[string]total{asha: {"Asha K", 2, 1, 0, 0}},
+		},
+		{
+			"delete removes the player until they hit again",
+			[]BallEvent{testBall(asha, 1, 6), testBall(ravi, 1, 4), testCorrection(BALL_KIND_DELETE, asha, 0, ""), testBall(ravi, 2, 1)},
+			map[string]total{ravi: {"Asha", 5, 2, 1, 0}},
+		},
+		{
+			"delete then a fresh start",
+			[]BallEvent{testBall(asha, 1, 6), testCorrection(BALL_KIND_DELETE, asha, 0, ""), testBall(asha, 2, 1)},
+			map[string]total{asha: {"Asha", 1, 1, 0, 0}},
+		},
+		{
+			"reset wipes everyone before it",
+			[]BallEvent{testBall(asha, 1, 6), testBall(ravi, 1, 4), testCorrection(BALL_KIND_RESET, "", 0, ""), testBall(ravi, 2, 2)},
+			map[string]total{ravi: {"Asha", 2, 1, 0, 0}},
+		},
+		{
+			"other events are left out",
+			[]BallEvent{testBall(asha, 1, 1), otherEvent},
+			map[string]total{asha: {"Asha", 1, 1, 0, 0}},
+		},
+	}
+	for _, tt := range tests {
+		log := &memoryBallLog{ids: make(map[string]bool)}
+		if err := log.AppendMany(context.Background(), tt.balls); err != nil {
+			t.Fatal(err)
+		}
+		students, err := totalsFromBallLog(context.Background(), log, DEFAULT_EVENT_ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]total)
+		for _, s := range students {
+			got[s.RollNumber] = total{s.Name, s.Score, s.BallsFaced, s.Fours, s.Sixes}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Rebuilding from the ball log after hits and moderator corrections must
+// give back the totals the store kept live
+func TestRebuildMatchesLiveTotals(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"ADMIN_KEY":             testAdminKey,
+		"RATE_LIMIT_ROLL_BURST": "10",
+		"RATE_LIMIT_IP_BURST":   "100",
+	})
+	ctx := context.Background()
+	for _, roll := range []string{"2021000001", "2021000002", "2021000003"} {
+		for i := 0; i < 3; i++ {
+			if rec := serveJSON(server, "POST", "/hit", `{"rollNumber":"`+roll+`","name":"Player"}`); rec.Code != http.StatusOK {
+				t.Fatalf("hit %s: %d %s", roll, rec.Code, rec.Body.String())
+			}
+		}
+	}
+	corrections := []struct{ method, path, body string }{
+		{"POST", "/admin/students/2021000001/adjust", `{"runs": 7, "reason": "missed boundary"}`},
+		{"POST", "/admin/students/2021000002/rename", `{"name": "Ravi"}`},
+		{"DELETE", "/admin/students/2021000003", `{"reason": "duplicate"}`},
+	}
+	for _, c := range corrections {
+		if rec := serveAdmin(server, c.method, c.path, c.body); rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: %d %s", c.method, c.path, rec.Code, rec.Body.String())
+		}
+	}
+	// A hit after the rename is logged under the moderator's name
+	if rec := serveJSON(server, "POST", "/hit", `{"rollNumber":"2021000002","name":"Player"}`); rec.Code != http.StatusOK {
+		t.Fatalf("hit after rename: %d %s", rec.Code, rec.Body.String())
+	}
+
+	ev, _ := getEvent(DEFAULT_EVENT_ID)
+	live, err := ev.store.Leaderboard(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rebuildEvent(ctx, ev.store, ballLog, DEFAULT_EVENT_ID); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := ev.store.Leaderboard(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(live) != 2 || len(rebuilt) != len(live) {
+		t.Fatalf("live board has %d students and the rebuilt one %d, want 2", len(live), len(rebuilt))
+	}
+	for i := range live {
+		want, got := live[i], rebuilt[i]
+		want.LastPlayed, got.LastPlayed = want.LastPlayed.Round(time.Millisecond), got.LastPlayed.Round(time.Millisecond)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("rebuilt %+v, live store had %+v", got, want)
+		}
+	}
+}
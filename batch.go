@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	BATCH_DEFAULT_FLUSH_MS   = 100
+	BATCH_DEFAULT_MAX_OPS    = 500
+	BATCH_FLUSH_TIMEOUT_SECS = 30
+)
+
+// hitBatcher is nil unless HIT_BATCHING=true, in which case hitShot hands
+// hits to it instead of writing them to Mongo one by one
+var hitBatcher *HitBatcher
+
+// HitBatcher is the write-behind path for hits. A hit is acknowledged as
+// soon as it is in the local journal and on the in-memory boards; the
+// balls are collapsed per player and flushed with one bulk write every
+// flush interval or max ops, whichever comes first.
+type HitBatcher struct {
+	journal  *Journal
+	interval time.Duration
+	maxOps   int
+
+	mu      sync.Mutex // Guards the pending batch and projecting onto the boards
+	pending *hitBatch
+
+	flushMu     sync.Mutex  // Serialises flushes
+	failed      []*hitBatch // Batches whose flush failed, oldest first; retried before anything newer
+	failedCount atomic.Int64
+
+	kick    chan struct{}
+	closing chan struct{}
+	stopped chan struct{}
+}
+
+// hitBatch is the balls accepted during one flush interval
+type hitBatch struct {
+	balls  []BallEvent
+	deltas map[string]map[string]*StudentDelta // Event ID -> roll number -> delta
+	seqs   []uint64                            // Journal entries in the batch
+}
+
+func newHitBatch() *hitBatch {
+	return &hitBatch{deltas: make(map[string]map[string]*StudentDelta)}
+}
+
+func (b *hitBatch) add(seq uint64, ball BallEvent) {
+	b.balls = append(b.balls, ball)
+	byRoll, ok := b.deltas[ball.EventID]
+	if !ok {
+		byRoll = make(map[string]*StudentDelta)
+		b.deltas[ball.EventID] = byRoll
+	}
+	delta, ok := byRoll[ball.RollNumber]
+	if !ok {
+		delta = &StudentDelta{}
+		byRoll[ball.RollNumber] = delta
+	}
+	delta.add(ball)
+	b.seqs = append(b.seqs, seq)
+}
+
+// loadBatchConfig starts the batcher if HIT_BATCHING=true. BATCH_FLUSH_MS
+// and BATCH_MAX_OPS tune the flush. Batching needs the hit journal, so call
+// this after loadJournalConfig.
+func loadBatchConfig() {
+	cfg := currentConfig().Journal
+	if !cfg.Batching {
+		return
+	}
+
+	hitBatcher = &HitBatcher{
+		journal:  hitJournal,
+		interval: time.Duration(cfg.FlushMs) * time.Millisecond,
+		maxOps:   cfg.MaxOps,
+		pending:  newHitBatch(),
+		kick:     make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	logger.Info("hit batching on", "flush_every", hitBatcher.interval.String(), "max_hits", hitBatcher.maxOps)
+	go hitBatcher.run()
+}
+
+// Record journals a ball, moves the player on the boards and queues the
+// ball for the next flush. It returns once the ball is on disk.
+func (b *HitBatcher) Record(ctx context.Context, ev *eventRuntime, ball BallEvent) (*Student, error) {
+	seq, err := b.journal.Append(ball)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	student := ev.project(ball)
+	b.pending.add(seq, ball)
+	full := len(b.pending.balls) >= b.maxOps
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.kick <- struct{}{}:
+		default:
+		}
+	}
+	return &student, nil
+}
+
+// Pending reports hits waiting for the next flush and batches waiting for
+// a retry
+func (b *HitBatcher) Pending() (hits, failedBatches int) {
+	b.mu.Lock()
+	hits = len(b.pending.balls)
+	b.mu.Unlock()
+	return hits, int(b.failedCount.Load())
+}
+
+func (b *HitBatcher) run() {
+	defer close(b.stopped)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.closing:
+			return
+		case <-ticker.C:
+		case <-b.kick:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), BATCH_FLUSH_TIMEOUT_SECS*time.Second)
+		if err := b.Flush(ctx); err != nil {
+			hits, failed := b.Pending()
+			logger.Error("hit batch flush failed", "error", err.Error(), "pending_hits", hits, "failed_batches", failed)
+		}
+		cancel()
+	}
+}
+
+// Flush writes everything pending to the store. A batch that fails stays
+// queued and is retried, in order, on the next flush; the journal keeps
+// its hits until it succeeds.
+func (b *HitBatcher) Flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	if len(b.pending.balls) > 0 {
+		b.failed = append(b.failed, b.pending)
+		b.pending = newHitBatch()
+	}
+	b.mu.Unlock()
+
+	defer func() { b.failedCount.Store(int64(len(b.failed))) }()
+	for len(b.failed) > 0 {
+		batch := b.failed[0]
+		start := time.Now()
+		err := b.write(ctx, batch)
+		observeDB("batch_flush", start, err)
+		if err != nil {
+			journalStoreDown.Store(true)
+			return err
+		}
+		b.failed = b.failed[1:]
+		b.journal.MarkApplied(batch.seqs...)
+		stored := make(map[string]int)
+		for _, ball := range batch.balls {
+			stored[ball.EventID]++
+		}
+		storedHits(stored)
+	}
+	journalStoreDown.Store(false)
+	if err := b.journal.Checkpoint(); err != nil {
+		return fmt.Errorf("journal checkpoint: %w", err)
+	}
+	return nil
+}
+
+// write stores one batch. Both writes skip what is already there, so
+// writing the same batch again after a partial failure is safe.
+func (b *HitBatcher) write(ctx context.Context, batch *hitBatch) error {
+	if err := ballLog.AppendMany(ctx, batch.balls); err != nil {
+		return err
+	}
+	for eventID, byRoll := range batch.deltas {
+		ev, ok := getEvent(eventID)
+		if !ok {
+			return fmt.Errorf("event %s is not loaded", eventID)
+		}
+		deltas := make([]StudentDelta, 0, len(byRoll))
+		for _, delta := range byRoll {
+			deltas = append(deltas, *delta)
+		}
+		if err := ev.store.ApplyBatch(ctx, deltas); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the flush loop and writes what is left. Anything that still
+// cannot be written stays in the journal for the next start.
+func (b *HitBatcher) Close(ctx context.Context) error {
+	close(b.closing)
+	<-b.stopped
+	return b.Flush(ctx)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestBatchedHitsStayOnTheBoard(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"ADMIN_KEY":             testAdminKey,
+		"HIT_JOURNAL":           "true",
+		"HIT_BATCHING":          "true",
+		"BATCH_FLUSH_MS":        "3600000", // Only the test flushes
+		"RATE_LIMIT_ROLL_BURST": "10",
+	})
+	ctx := context.Background()
+	ev, _ := getEvent(DEFAULT_EVENT_ID)
+	const roll = "2021000001"
+
+	hit := func() {
+		t.Helper()
+		if rec := serveJSON(server, "POST", "/hit", `{"rollNumber":"`+roll+`","name":"Asha"}`); rec.Code != http.StatusOK {
+			t.Fatalf("hit: %d %s", rec.Code, rec.Body.String())
+		}
+	}
+	onBoard := func() Student {
+		t.Helper()
+		student, ok := ev.leaderboard.Get(roll)
+		if !ok {
+			t.Fatal("student is not on the board")
+		}
+		return student
+	}
+
+	// The player's only hits are still waiting for a flush
+	hit()
+	hit()
+	if err := ev.refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if balls := onBoard().BallsFaced; balls != 2 {
+		t.Fatalf("after a refresh the board shows %d balls, want 2", balls)
+	}
+
+	rec := serveJSON(server, "GET", "/students/"+roll, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("profile: %d %s", rec.Code, rec.Body.String())
+	}
+	var profile StudentProfile
+	json.NewDecoder(rec.Body).Decode(&profile)
+	if profile.BallsFaced != 2 {
+		t.Errorf("profile shows %d balls, want 2", profile.BallsFaced)
+	}
+
+	// Admin actions find the player and keep the unflushed hits
+	if rec := serveAdmin(server, "POST", "/admin/students/"+roll+"/rename", `{"name":"Asha R"}`); rec.Code != http.StatusOK {
+		t.Fatalf("rename: %d %s", rec.Code, rec.Body.String())
+	}
+	hit()
+	before := onBoard()
+	if rec := serveAdmin(server, "POST", "/admin/students/"+roll+"/adjust", `{"runs":4,"reason":"missed boundary"}`); rec.Code != http.StatusOK {
+		t.Fatalf("adjust: %d %s", rec.Code, rec.Body.String())
+	}
+	after := onBoard()
+	if after.BallsFaced != 3 || after.Score != before.Score+4 || after.Name != "Asha R" {
+		t.Errorf("board shows %+v after adjusting %+v by 4", after, before)
+	}
+
+	if err := hitBatcher.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ev.refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := ev.store.GetStudent(ctx, roll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := onBoard(); got.BallsFaced != stored.BallsFaced || got.Score != stored.Score {
+		t.Errorf("board %+v and store %+v disagree once flushed", got, *stored)
+	}
+}
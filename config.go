@@ -0,0 +1,552 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Config is every setting the server reads. Values are layered, lowest
+// first: the defaults in defaultConfig, a JSON config file (-config or
+// CONFIG_FILE), environment variables, then command line flags. Each
+// setting's env tag names its variable; its flag is the same name in lower
+// case with dashes, so RATE_LIMIT_IP_BURST is -rate-limit-ip-burst.
+//
+// Settings tagged reload are re-read on SIGHUP; the rest need a restart.
+// Settings tagged secret are redacted when the config is printed.
+type Config struct {
+	Server     ServerConfig     `json:"server"`
+	Log        LogConfig        `json:"log"`
+	Store      StoreConfig      `json:"store"`
+	Scoreboard ScoreboardConfig `json:"scoreboard"`
+	Game       GameConfig       `json:"game"`
+	Players    PlayersConfig    `json:"players"`
+	RateLimit  RateLimitConfig  `json:"rateLimit"`
+	Auth       AuthConfig       `json:"auth"`
+	Journal    JournalConfig    `json:"journal"`
+	Debug      DebugConfig      `json:"debug"`
+}
+
+type ServerConfig struct {
+	Port                   string `json:"port" env:"PORT"`
+	ShutdownTimeoutSeconds int    `json:"shutdownTimeoutSeconds" env:"SHUTDOWN_TIMEOUT_SECONDS" reload:"true"`
+	IdempotencyTTLSeconds  int    `json:"idempotencyTtlSeconds" env:"IDEMPOTENCY_TTL_SECONDS"`
+	// Proxies (IPs or CIDRs) whose X-Forwarded-For hops are believed. Empty
+	// means the socket address is the client.
+	TrustedProxies []string `json:"trustedProxies" env:"TRUSTED_PROXIES"`
+
+	// Filled in by resolve
+	trustedProxies []netip.Prefix
+}
+
+type LogConfig struct {
+	Level string `json:"level" env:"LOG_LEVEL" reload:"true"`
+}
+
+type StoreConfig struct {
+	Backend            string `json:"backend" env:"STORE_BACKEND"`
+	MongoURI           string `json:"mongoUri" env:"MONGODB_URI" secret:"true"`
+	Database           string `json:"database" env:"MONGODB_DATABASE"`
+	StudentsCollection string `json:"studentsCollection" env:"STUDENTS_COLLECTION"` // Other events add "_<eventId>"
+	TimeoutMs          int    `json:"timeoutMs" env:"DB_TIMEOUT_MS" reload:"true"`  // Per request
+}
+
+type ScoreboardConfig struct {
+	CacheTTLSeconds float64 `json:"cacheTtlSeconds" env:"CACHE_TTL_SECONDS" reload:"true"`
+}
+
+type GameConfig struct {
+	ShotWeights string `json:"shotWeights" env:"SHOT_WEIGHTS"`
+	ShotSeed    int64  `json:"shotSeed" env:"SHOT_SEED"` // 0 seeds from the clock
+}
+
+type PlayersConfig struct {
+	RollNumberPattern string   `json:"rollNumberPattern" env:"ROLL_NUMBER_PATTERN"`
+	RollNumberMessage string   `json:"rollNumberMessage" env:"ROLL_NUMBER_MESSAGE"` // Shown when the pattern doesn't match
+	NameMaxLength     int      `json:"nameMaxLength" env:"NAME_MAX_LENGTH" reload:"true"`
+	NameBlocklist     []string `json:"nameBlocklist" env:"NAME_BLOCKLIST" reload:"true"`
+	NameBlocklistFile string   `json:"nameBlocklistFile" env:"NAME_BLOCKLIST_FILE" reload:"true"`
+	RosterStrict      bool     `json:"rosterStrict" env:"ROSTER_STRICT"`
+	TeamNames         []string `json:"teamNames" env:"TEAM_NAMES"`
+	TeamRule          string   `json:"teamRule" env:"TEAM_RULE"`
+
+	// Filled in by resolve
+	rollNumber *regexp.Regexp
+	blocklist  []blockedWord
+}
+
+type RateLimitConfig struct {
+	Backend       string  `json:"backend" env:"RATE_LIMIT_BACKEND"` // Empty follows the store
+	RollBurst     int     `json:"rollBurst" env:"RATE_LIMIT_ROLL_BURST" reload:"true"`
+	RollPerSecond float64 `json:"rollPerSecond" env:"RATE_LIMIT_ROLL_PER_SECOND" reload:"true"`
+	IPBurst       int     `json:"ipBurst" env:"RATE_LIMIT_IP_BURST" reload:"true"`
+	IPPerSecond   float64 `json:"ipPerSecond" env:"RATE_LIMIT_IP_PER_SECOND" reload:"true"`
+	// Wrong join codes a roll number may try before /login locks it out
+	LoginFailureBurst     int     `json:"loginFailureBurst" env:"RATE_LIMIT_LOGIN_FAILURE_BURST" reload:"true"`
+	LoginFailurePerSecond float64 `json:"loginFailurePerSecond" env:"RATE_LIMIT_LOGIN_FAILURE_PER_SECOND" reload:"true"`
+	// Admin requests per client IP, counted apart from hits and logins
+	AdminBurst     int     `json:"adminBurst" env:"RATE_LIMIT_ADMIN_BURST" reload:"true"`
+	AdminPerSecond float64 `json:"adminPerSecond" env:"RATE_LIMIT_ADMIN_PER_SECOND" reload:"true"`
+}
+
+type AuthConfig struct {
+	Mode            string `json:"mode" env:"AUTH_MODE"`
+	SessionSecret   string `json:"sessionSecret" env:"SESSION_SECRET" secret:"true"`
+	SessionTTLHours int    `json:"sessionTtlHours" env:"SESSION_TTL_HOURS"`
+	AdminKey        string `json:"adminKey" env:"ADMIN_KEY" secret:"true"`
+}
+
+type JournalConfig struct {
+	Enabled     bool   `json:"enabled" env:"HIT_JOURNAL"` // Off by default: it needs a disk that outlives the process
+	Dir         string `json:"dir" env:"JOURNAL_DIR"`     // No default; a persistent volume
+	Batching    bool   `json:"batching" env:"HIT_BATCHING"`
+	FlushMs     int    `json:"flushMs" env:"BATCH_FLUSH_MS"`
+	MaxOps      int    `json:"maxOps" env:"BATCH_MAX_OPS"`
+	ReadyMaxLag int    `json:"readyMaxLag" env:"READY_MAX_JOURNAL_LAG" reload:"true"`
+}
+
+type DebugConfig struct {
+	Enabled bool   `json:"enabled" env:"DEBUG_ENABLED"`
+	Addr    string `json:"addr" env:"DEBUG_ADDR"`
+	Token   string `json:"token" env:"DEBUG_TOKEN" secret:"true" reload:"true"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:                   "9000",
+			ShutdownTimeoutSeconds: SHUTDOWN_DEFAULT_TIMEOUT_SECONDS,
+			IdempotencyTTLSeconds:  3600,
+		},
+		Log: LogConfig{Level: "info"},
+		Store: StoreConfig{
+			Backend:            "mongo",
+			Database:           "cricket_db",
+			StudentsCollection: "students",
+			TimeoutMs:          5000,
+		},
+		Scoreboard: ScoreboardConfig{CacheTTLSeconds: 2},
+		Game:       GameConfig{ShotWeights: DEFAULT_SHOT_WEIGHTS},
+		Players: PlayersConfig{
+			RollNumberPattern: `^\d{10}$`,
+			RollNumberMessage: "Roll number must be exactly 10 digits",
+			NameMaxLength:     NAME_DEFAULT_MAX_LENGTH,
+			TeamRule:          "last-digit",
+		},
+		// The roll number defaults match the old fixed gap of one hit every
+		// 2 seconds
+		RateLimit: RateLimitConfig{RollBurst: 1, RollPerSecond: 0.5, IPBurst: 20, IPPerSecond: 5, LoginFailureBurst: 5, LoginFailurePerSecond: 1.0 / 60, AdminBurst: 10, AdminPerSecond: 1},
+		Auth:      AuthConfig{Mode: "off", SessionTTLHours: 12},
+		Journal: JournalConfig{
+			FlushMs:     BATCH_DEFAULT_FLUSH_MS,
+			MaxOps:      BATCH_DEFAULT_MAX_OPS,
+			ReadyMaxLag: READY_DEFAULT_MAX_JOURNAL_LAG,
+		},
+		Debug: DebugConfig{Addr: DEBUG_DEFAULT_ADDR},
+	}
+}
+
+var (
+	// The config in effect; swapped whole on SIGHUP
+	activeConfig atomic.Pointer[Config]
+
+	// Where the config came from, so a reload reads the same file and
+	// keeps the same flags
+	activeSource configSource
+)
+
+func currentConfig() *Config {
+	return activeConfig.Load()
+}
+
+// dbTimeout bounds the store calls made while answering a request
+func dbTimeout() time.Duration {
+	return time.Duration(currentConfig().Store.TimeoutMs) * time.Millisecond
+}
+
+type configSource struct {
+	file  string
+	flags [][2]string // Env name and raw value, in command line order
+}
+
+// configField is one leaf setting of a Config
+type configField struct {
+	path   string // e.g. "rateLimit.ipBurst"
+	env    string
+	value  reflect.Value
+	reload bool
+	secret bool
+}
+
+func (c *Config) fields() []configField {
+	var fields []configField
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		sectionName := jsonName(sections.Type().Field(i))
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			if !field.IsExported() {
+				continue
+			}
+			fields = append(fields, configField{
+				path:   sectionName + "." + jsonName(field),
+				env:    field.Tag.Get("env"),
+				value:  section.Field(j),
+				reload: field.Tag.Get("reload") == "true",
+				secret: field.Tag.Get("secret") == "true",
+			})
+		}
+	}
+	return fields
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return name
+}
+
+func flagName(env string) string {
+	return strings.ToLower(strings.ReplaceAll(env, "_", "-"))
+}
+
+// setFromString parses an env or flag value into a setting. Lists are
+// comma separated.
+func (f configField) setFromString(raw string) error {
+	switch f.value.Kind() {
+	case reflect.String:
+		f.value.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer", f.env)
+		}
+		f.value.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number", f.env)
+		}
+		f.value.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s must be \"true\" or \"false\"", f.env)
+		}
+		f.value.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("%s: unsupported setting type %s", f.env, f.value.Kind())
+	}
+	return nil
+}
+
+// parseConfigFlags reads the command line. Flag values are only recorded
+// here; loadConfig applies them last so they beat the file and environment.
+func parseConfigFlags(args []string) (source configSource, printOnly bool, err error) {
+	fs := flag.NewFlagSet("cricket", flag.ContinueOnError)
+	fs.StringVar(&source.file, "config", os.Getenv("CONFIG_FILE"), "JSON config file (CONFIG_FILE)")
+	fs.BoolVar(&printOnly, "print-config", false, "print the effective config and exit")
+	for _, field := range defaultConfig().fields() {
+		env := field.env
+		usage := fmt.Sprintf("%s (%s)", field.path, env)
+		fs.Func(flagName(env), usage, func(raw string) error {
+			source.flags = append(source.flags, [2]string{env, raw})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return source, false, err
+	}
+	if fs.NArg() > 0 {
+		return source, false, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	return source, printOnly, nil
+}
+
+// loadConfig builds, checks and resolves a config from source
+func loadConfig(source configSource) (*Config, error) {
+	cfg := defaultConfig()
+	if source.file != "" {
+		if err := cfg.readFile(source.file); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := cfg.fields()
+	byEnv := make(map[string]configField, len(fields))
+	var problems []error
+	for _, field := range fields {
+		byEnv[field.env] = field
+		if raw := os.Getenv(field.env); raw != "" {
+			if err := field.setFromString(raw); err != nil {
+				problems = append(problems, err)
+			}
+		}
+	}
+	for _, flagValue := range source.flags {
+		if err := byEnv[flagValue[0]].setFromString(flagValue[1]); err != nil {
+			problems = append(problems, fmt.Errorf("-%s: %w", flagName(flagValue[0]), err))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errors.Join(problems...)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.resolve(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readFile overlays a JSON config file. Unknown keys are refused so a typo
+// doesn't silently leave a default in place.
+func (c *Config) readFile(path string) error {
+	if ext := strings.ToLower(filepath.Ext(path)); ext != ".json" {
+		return fmt.Errorf("config file %s: only JSON (.json) is supported", path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks every setting and reports all the problems at once
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	port, err := strconv.Atoi(c.Server.Port)
+	check(err == nil && port > 0 && port < 65536, "PORT must be a port number")
+	check(c.Server.ShutdownTimeoutSeconds > 0, "SHUTDOWN_TIMEOUT_SECONDS must be a positive integer")
+	check(c.Server.IdempotencyTTLSeconds > 0, "IDEMPOTENCY_TTL_SECONDS must be a positive integer")
+
+	_, err = parseLogLevel(c.Log.Level)
+	check(err == nil, "LOG_LEVEL must be debug, info, warn or error")
+
+	check(c.Store.Backend == "mongo" || c.Store.Backend == "memory", "STORE_BACKEND must be \"mongo\" or \"memory\"")
+	check(c.Store.Backend != "mongo" || c.Store.MongoURI != "", "MONGODB_URI is required with STORE_BACKEND=mongo")
+	check(c.Store.Database != "" && !strings.ContainsAny(c.Store.Database, "/\\. \"$*<>:|?"), "MONGODB_DATABASE is not a valid database name")
+	check(c.Store.StudentsCollection != "" && !strings.Contains(c.Store.StudentsCollection, "$") &&
+		!strings.HasPrefix(c.Store.StudentsCollection, "system."), "STUDENTS_COLLECTION is not a valid collection name")
+	check(c.Store.TimeoutMs > 0, "DB_TIMEOUT_MS must be a positive integer")
+
+	check(c.Scoreboard.CacheTTLSeconds >= 0, "CACHE_TTL_SECONDS must not be negative")
+
+	_, err = NewOutcomeEngine(1, c.Game.ShotWeights)
+	check(err == nil, "SHOT_WEIGHTS: %v", err)
+
+	_, err = regexp.Compile(c.Players.RollNumberPattern)
+	check(err == nil, "ROLL_NUMBER_PATTERN: %v", err)
+	check(c.Players.NameMaxLength > 0, "NAME_MAX_LENGTH must be a positive integer")
+	check(c.Players.TeamRule == "last-digit" || c.Players.TeamRule == "none", "TEAM_RULE must be \"last-digit\" or \"none\"")
+
+	switch c.RateLimit.Backend {
+	case "", "memory":
+	case "mongo":
+		check(c.Store.Backend == "mongo", "RATE_LIMIT_BACKEND=mongo needs STORE_BACKEND=mongo")
+	default:
+		check(false, "RATE_LIMIT_BACKEND must be \"memory\" or \"mongo\"")
+	}
+	check(c.RateLimit.RollBurst > 0, "RATE_LIMIT_ROLL_BURST must be a positive integer")
+	check(c.RateLimit.RollPerSecond > 0, "RATE_LIMIT_ROLL_PER_SECOND must be a positive number")
+	check(c.RateLimit.IPBurst > 0, "RATE_LIMIT_IP_BURST must be a positive integer")
+	check(c.RateLimit.IPPerSecond > 0, "RATE_LIMIT_IP_PER_SECOND must be a positive number")
+	check(c.RateLimit.LoginFailureBurst > 0, "RATE_LIMIT_LOGIN_FAILURE_BURST must be a positive integer")
+	check(c.RateLimit.LoginFailurePerSecond > 0, "RATE_LIMIT_LOGIN_FAILURE_PER_SECOND must be a positive number")
+	check(c.RateLimit.AdminBurst > 0, "RATE_LIMIT_ADMIN_BURST must be a positive integer")
+	check(c.RateLimit.AdminPerSecond > 0, "RATE_LIMIT_ADMIN_PER_SECOND must be a positive number")
+
+	check(c.Auth.Mode == "off" || c.Auth.Mode == "optional" || c.Auth.Mode == "required", "AUTH_MODE must be \"off\", \"optional\" or \"required\"")
+	check(c.Auth.Mode == "off" || c.Auth.SessionSecret != "", "SESSION_SECRET is required unless AUTH_MODE=off")
+	check(c.Auth.SessionSecret == "" || len(c.Auth.SessionSecret) >= 32, "SESSION_SECRET must be at least 32 characters")
+	check(c.Auth.SessionTTLHours > 0, "SESSION_TTL_HOURS must be a positive integer")
+
+	check(!c.Journal.Enabled || c.Journal.Dir != "", "HIT_JOURNAL=true needs JOURNAL_DIR, a directory on a persistent volume")
+	check(!c.Journal.Batching || c.Journal.Enabled, "HIT_BATCHING needs the hit journal; set HIT_JOURNAL=true")
+	check(c.Journal.FlushMs > 0, "BATCH_FLUSH_MS must be a positive integer")
+	check(c.Journal.MaxOps > 0, "BATCH_MAX_OPS must be a positive integer")
+	check(c.Journal.ReadyMaxLag > 0, "READY_MAX_JOURNAL_LAG must be a positive integer")
+
+	if c.Debug.Enabled {
+		_, _, err := net.SplitHostPort(c.Debug.Addr)
+		check(err == nil, "DEBUG_ADDR must be host:port")
+		check(len(c.Debug.Token) >= DEBUG_MIN_TOKEN_LENGTH, "DEBUG_TOKEN must be at least %d characters", DEBUG_MIN_TOKEN_LENGTH)
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// resolve fills in what is derived from the settings: the parsed trusted
+// proxy prefixes, the compiled roll number pattern and the folded name
+// blocklist
+func (c *Config) resolve() error {
+	c.Server.trustedProxies = nil
+	for _, proxy := range c.Server.TrustedProxies {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				return fmt.Errorf("TRUSTED_PROXIES: %q is not an IP or CIDR", proxy)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		c.Server.trustedProxies = append(c.Server.trustedProxies, prefix.Masked())
+	}
+
+	c.Players.rollNumber = regexp.MustCompile(c.Players.RollNumberPattern)
+
+	blocklist, err := readBlocklist(c.Players.NameBlocklist, c.Players.NameBlocklistFile)
+	if err != nil {
+		return fmt.Errorf("NAME_BLOCKLIST_FILE: %w", err)
+	}
+	c.Players.blocklist = blocklist
+	return nil
+}
+
+// mustLoadConfig loads the config at startup and makes it current. A bad
+// setting stops the process with exit code 2 before anything is opened.
+func mustLoadConfig(source configSource) {
+	cfg, err := loadConfig(source)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid config:\n"+err.Error())
+		os.Exit(2)
+	}
+	activeSource = source
+	activeConfig.Store(cfg)
+}
+
+// redactedConfig is a copy of cfg with the secrets blanked out
+func redactedConfig(cfg *Config) *Config {
+	shown := *cfg
+	for _, field := range shown.fields() {
+		if field.secret && field.value.String() != "" {
+			field.value.SetString("[redacted]")
+		}
+	}
+	return &shown
+}
+
+// printConfig writes the config as JSON with secrets redacted
+func printConfig(w io.Writer, cfg *Config) {
+	out, _ := json.MarshalIndent(redactedConfig(cfg), "", "  ")
+	fmt.Fprintf(w, "%s\n", out)
+}
+
+// reloadConfig re-reads the config file and environment (flags stay as
+// they were) and applies the reloadable settings. Changes to the others
+// are reported but wait for a restart. On any error the running config is
+// kept.
+func reloadConfig() error {
+	next, err := loadConfig(activeSource)
+	if err != nil {
+		return err
+	}
+	// TEAM_NAMES only changes on a restart; refuse now rather than have
+	// that restart fail on the roster
+	if roster != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := checkRosterTeams(ctx, next.Players.TeamNames)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	current := currentConfig()
+	merged := *current
+
+	var changed, needRestart []string
+	currentFields, nextFields, mergedFields := current.fields(), next.fields(), merged.fields()
+	for i, field := range currentFields {
+		if reflect.DeepEqual(field.value.Interface(), nextFields[i].value.Interface()) {
+			continue
+		}
+		if !field.reload {
+			needRestart = append(needRestart, field.env)
+			continue
+		}
+		mergedFields[i].value.Set(nextFields[i].value)
+		changed = append(changed, field.env)
+	}
+	merged.Players.blocklist = next.Players.blocklist
+
+	activeConfig.Store(&merged)
+	applyReloadableConfig(&merged)
+
+	logger.Info("config reloaded", "changed", changed, "blocklist_words", len(merged.Players.blocklist))
+	if len(needRestart) > 0 {
+		logger.Warn("config changes need a restart", "settings", needRestart)
+	}
+	return nil
+}
+
+// applyReloadableConfig pushes reloadable settings into the parts that
+// hold their own copy. Everything else reads currentConfig when it needs
+// a value.
+func applyReloadableConfig(cfg *Config) {
+	level, _ := parseLogLevel(cfg.Log.Level)
+	logLevel.Set(level)
+
+	rollLimiter.SetPolicy(RateLimitPolicy{Burst: cfg.RateLimit.RollBurst, RefillPerSecond: cfg.RateLimit.RollPerSecond})
+	ipLimiter.SetPolicy(RateLimitPolicy{Burst: cfg.RateLimit.IPBurst, RefillPerSecond: cfg.RateLimit.IPPerSecond})
+	loginLimiter.SetPolicy(RateLimitPolicy{Burst: cfg.RateLimit.LoginFailureBurst, RefillPerSecond: cfg.RateLimit.LoginFailurePerSecond})
+	adminLimiter.SetPolicy(RateLimitPolicy{Burst: cfg.RateLimit.AdminBurst, RefillPerSecond: cfg.RateLimit.AdminPerSecond})
+}
+
+// watchConfigReload reloads the config on every SIGHUP until ctx ends
+func watchConfigReload(ctx context.Context) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			if err := reloadConfig(); err != nil {
+				logger.Error("config reload failed, keeping the running config", "error", err.Error())
+			}
+		}
+	}
+}
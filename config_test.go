@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cricket.json")
+	file := `{
+		"server": {"port": "7000", "shutdownTimeoutSeconds": 5},
+		"rateLimit": {"rollBurst": 3, "ipBurst": 30}
+	}`
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("STORE_BACKEND", "memory")
+	t.Setenv("SHUTDOWN_TIMEOUT_SECONDS", "6")
+	t.Setenv("RATE_LIMIT_IP_BURST", "40")
+
+	source, _, err := parseConfigFlags([]string{"-config", path, "-rate-limit-ip-burst", "50"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		setting   string
+		got, want interface{}
+	}{
+		{"default", cfg.RateLimit.IPPerSecond, 5.0},
+		{"file over default", cfg.Server.Port, "7000"},
+		{"file over default", cfg.RateLimit.RollBurst, 3},
+		{"env over file", cfg.Server.ShutdownTimeoutSeconds, 6},
+		{"flag over env and file", cfg.RateLimit.IPBurst, 50},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.setting, tt.got, tt.want)
+		}
+	}
+}
+
+func TestConfigReloadSubset(t *testing.T) {
+	newTestServer(t, map[string]string{"RATE_LIMIT_IP_BURST": "20"})
+	before := *currentConfig()
+
+	t.Setenv("RATE_LIMIT_IP_BURST", "2")      // Reloadable
+	t.Setenv("SHUTDOWN_TIMEOUT_SECONDS", "3") // Reloadable
+	t.Setenv("PORT", "1234")                  // Needs a restart
+	t.Setenv("TEAM_NAMES", "Lions,Tigers")    // Needs a restart
+	if err := reloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := currentConfig()
+	if cfg.RateLimit.IPBurst != 2 || cfg.Server.ShutdownTimeoutSeconds != 3 {
+		t.Errorf("reloadable settings not applied: ipBurst %d, shutdownTimeoutSeconds %d",
+			cfg.RateLimit.IPBurst, cfg.Server.ShutdownTimeoutSeconds)
+	}
+	if cfg.Server.Port != before.Server.Port || !reflect.DeepEqual(cfg.Players.TeamNames, before.Players.TeamNames) {
+		t.Errorf("restart-only settings changed: port %q, teams %v", cfg.Server.Port, cfg.Players.TeamNames)
+	}
+
+	// The limiters hold their own copy of the policy
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		decision, err := ipLimiter.TryAcquire(ctx, "203.0.113.9")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := i < 2; decision.Allowed != want {
+			t.Errorf("request %d: allowed %v, want %v with a burst of 2", i+1, decision.Allowed, want)
+		}
+	}
+}
@@ -2,26 +2,30 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
-	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	_ "net/http/pprof" // pprof for profiling
-
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
-	RATE_LIMIT_SECONDS = 2 // Minimum seconds between hits per student
-	CACHE_TTL_SECONDS  = 2 // Scoreboard cache TTL
+	SCOREBOARD_DEFAULT_LIMIT  = 100
+	SCOREBOARD_MAX_LIMIT      = 500
+	SCOREBOARD_DEFAULT_RADIUS = 5
+	SCOREBOARD_MAX_RADIUS     = 50
 )
 
 // // CONNECTION POOLING - COMMENTED OUT
@@ -41,15 +45,15 @@ const (
 // SINGLE CONNECTION - Uses MongoDB's built-in connection pooling (default 100)
 var (
 	mongoClient *mongo.Client
-	collection  *mongo.Collection
 
-	// Rate limiting: map of rollNumber -> last hit time
-	rateLimitMap   = make(map[string]time.Time)
-	rateLimitMutex sync.RWMutex
+	// Decides what each ball produces; the client's word is never trusted
+	outcomeEngine *OutcomeEngine
+
+	// Append-only history of every accepted ball
+	ballLog BallLog
 
-	// Scoreboard cache
-	cachedScoreboard     []Student
-	cacheLastUpdated     time.Time
+	// Scoreboard cache: encoded pages keyed by normalised query
+	scoreboardCache      = make(map[string]cachedPage)
 	scoreboardCacheMutex sync.RWMutex
 )
 
@@ -58,6 +62,16 @@ type Student struct {
 	Name       string    `json:"name" bson:"name"`
 	Score      int       `json:"score" bson:"score"`
 	LastPlayed time.Time `json:"lastPlayed" bson:"lastPlayed"`
+	// Result of the most recent ball (dot, 1, 2, 3, 4, 6 or out)
+	LastOutcome string `json:"lastOutcome,omitempty" bson:"lastOutcome,omitempty"`
+	BallsFaced  int    `json:"ballsFaced" bson:"ballsFaced"`
+	Fours       int    `json:"fours" bson:"fours"`
+	Sixes       int    `json:"sixes" bson:"sixes"`
+	Team        string `json:"team,omitempty" bson:"team,omitempty"`
+	// Balls counted in the last APPLIED_BALLS_RETENTION, so ApplyBatch can
+	// skip a replayed one. Only set when handing totals to ReplaceAll;
+	// reads leave it out.
+	AppliedBalls []appliedBall `json:"-" bson:"appliedBalls,omitempty"`
 }
 
 // // CONNECTION POOLING initDB - COMMENTED OUT
@@ -106,87 +120,143 @@ func initDB() {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
 
-	mongoURI := os.Getenv("MONGODB_URI")
-	if mongoURI == "" {
-		panic("MONGODB_URI environment variable is required")
-	}
-
 	var err error
-	mongoClient, err = mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	mongoClient, err = mongo.Connect(ctx, options.Client().ApplyURI(currentConfig().Store.MongoURI))
 	if err != nil {
-		fmt.Println(err.Error())
-		panic(err)
+		logger.Error("connecting to MongoDB", "error", err.Error())
+		os.Exit(1)
 	}
 
 	// Ping the database to verify connection
 	err = mongoClient.Ping(ctx, nil)
 	if err != nil {
-		fmt.Println(err.Error())
-		panic(err)
+		logger.Error("pinging MongoDB", "error", err.Error())
+		os.Exit(1)
 	}
 
-	collection = mongoClient.Database("cricket_db").Collection("students")
-
-	// Create unique index on rollNumber
-	indexModel := mongo.IndexModel{
-		Keys:    bson.D{{Key: "rollNumber", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	}
-	_, err = collection.Indexes().CreateOne(ctx, indexModel)
-	if err != nil {
-		fmt.Println("Index creation:", err.Error())
-	}
+	logger.Info("connected to MongoDB", "database", currentConfig().Store.Database)
+}
 
-	fmt.Println("Connected to MongoDB with built-in connection pooling (default: 100)")
+// mongoDatabase is the database every collection lives in (MONGODB_DATABASE)
+func mongoDatabase() *mongo.Database {
+	return mongoClient.Database(currentConfig().Store.Database)
 }
 
-// validateRollNumber checks if the roll number is exactly 10 digits
+// validateRollNumber checks the roll number against ROLL_NUMBER_PATTERN
+// (exactly 10 digits by default)
 func validateRollNumber(rollNumber string) bool {
-	matched, _ := regexp.MatchString(`^\d{10}$`, rollNumber)
-	return matched
+	return currentConfig().Players.rollNumber.MatchString(rollNumber)
 }
 
-// Check rate limit for a roll number
-func isRateLimited(rollNumber string) bool {
-	rateLimitMutex.RLock()
-	lastHit, exists := rateLimitMap[rollNumber]
-	rateLimitMutex.RUnlock()
+func hitShot(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
 
-	if exists && time.Since(lastHit).Seconds() < RATE_LIMIT_SECONDS {
-		return true
+	ev, ok := eventFromRequest(w, r)
+	if !ok {
+		return
 	}
-	return false
-}
-
-// Update rate limit timestamp
-func updateRateLimit(rollNumber string) {
-	rateLimitMutex.Lock()
-	rateLimitMap[rollNumber] = time.Now()
-	rateLimitMutex.Unlock()
-}
-
-func hitShot(w http.ResponseWriter, r *http.Request) {
-	requestStart := time.Now() // ⏱️ TIMING: Request start
 
-	w.Header().Add("Content-Type", "application/json")
+	// Archived and upcoming events are read-only
+	switch ev.Event().Status(time.Now()) {
+	case "archived":
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "This event has ended"})
+		return
+	case "upcoming":
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "This event has not started yet"})
+		return
+	}
 
+	// Any "shot" value sent by older clients is ignored
 	var input struct {
 		RollNumber string `json:"rollNumber"`
 		Name       string `json:"name"`
-		Shot       int    `json:"shot"`
+		Team       string `json:"team"` // Optional; only honoured on a player's first hit
+
+		IdempotencyKey string `json:"idempotencyKey"` // For clients that cannot send the header
 	}
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		fmt.Println(err.Error())
+		noteError(r.Context(), "", err)
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
 
+	// Logged-in players play as their session's roll number
+	rollNumber, err := authenticateHit(r, input.RollNumber)
+	if err == ErrNoSession || err == ErrBadSession {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Please log in with your join code"})
+		return
+	} else if err != nil {
+		noteError(r.Context(), "Checking session", err)
+		http.Error(w, "Error checking session", http.StatusInternalServerError)
+		return
+	}
+	input.RollNumber = rollNumber
+	noteRoll(r.Context(), rollNumber)
+
+	// Clean up the typed name. Roster and moderator names replace it below.
+	if input.Name != "" {
+		if input.Name, err = sanitizeName(input.Name); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
 	// Validate roll number (must be 10 digits)
 	if !validateRollNumber(input.RollNumber) {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Roll number must be exactly 10 digits"})
+		json.NewEncoder(w).Encode(map[string]string{"error": currentConfig().Players.RollNumberMessage})
+		return
+	}
+
+	// Not cancelled if the client goes away mid-write, but still carries
+	// the request's log fields
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), dbTimeout())
+	defer cancel()
+
+	// Registered students play under their roster name and team
+	entry, err := lookupPlayer(ctx, input.RollNumber)
+	if err == ErrNotRegistered {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Roll number is not registered for this game"})
+		return
+	} else if err != nil {
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error checking roster", http.StatusInternalServerError)
+		return
+	}
+	if entry != nil {
+		if rosterStrict {
+			input.Name = entry.Name
+		}
+		if entry.Team != "" {
+			input.Team = entry.Team
+		}
+	}
+
+	// Banned players are turned away, and a moderator's rename sticks
+	record, err := moderation.Get(ctx, input.RollNumber)
+	if err != nil && hitJournal != nil {
+		// The journal keeps hits through a store outage; don't lose them
+		// here instead
+		noteError(r.Context(), "Moderation", err)
+		record = &PlayerModeration{RollNumber: input.RollNumber}
+	} else if err != nil {
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error checking player", http.StatusInternalServerError)
 		return
 	}
+	if record.Banned {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "This roll number has been banned from playing"})
+		return
+	}
+	if record.DisplayName != "" {
+		input.Name = record.DisplayName
+	}
 
 	// Validate name
 	if input.Name == "" {
@@ -195,99 +265,345 @@ func hitShot(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check rate limit
-	if isRateLimited(input.RollNumber) {
+	team, err := assignTeam(input.RollNumber, input.Team)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	// A retry carrying the same Idempotency-Key gets the first answer back
+	// instead of playing another ball
+	key, err := idempotencyKey(r, input.IdempotencyKey, ev.ID(), input.RollNumber)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	if key != "" {
+		record, claimed, err := idempotency.Claim(ctx, key)
+		switch {
+		case err != nil:
+			noteError(r.Context(), "Idempotency", err)
+			key = "" // Play on without replay protection
+		case !claimed && !record.Done:
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "This shot is still being recorded. Please wait."})
+			return
+		case !claimed:
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(record.Status)
+			w.Write(record.Body)
+			return
+		}
+	}
+	completed := false
+	defer func() {
+		// Let the client retry for real if this attempt failed
+		if key != "" && !completed {
+			if err := idempotency.Release(context.Background(), key); err != nil {
+				noteError(r.Context(), "Idempotency", err)
+			}
+		}
+	}()
+
+	// Check and take the rate-limit tokens in one step
+	decision, limitedBy := tryAcquireHit(ctx, input.RollNumber, clientIP(r))
+	setRateLimitHeaders(w, decision)
+	switch limitedBy {
+	case "roll":
 		w.WriteHeader(http.StatusTooManyRequests)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Too many requests. Please wait a few seconds."})
 		return
+	case "ip":
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Too many requests from this device. Please slow down."})
+		return
 	}
 
-	// Update rate limit
-	updateRateLimit(input.RollNumber)
+	outcome := outcomeEngine.Play()
+	ball := newBallEvent(ev.ID(), input.RollNumber, input.Name, team, outcome, clientIP(r), requestID(r))
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	dbStart := time.Now()
+	student, failure, err := recordBall(ctx, ev, ball)
+	observeDB("record_hit", dbStart, err)
+	if err != nil {
+		noteError(r.Context(), "", err)
+		http.Error(w, failure, http.StatusInternalServerError)
+		return
+	}
 
-	// Upsert: update if exists, insert if not
-	filter := bson.M{"rollNumber": input.RollNumber}
-	update := bson.M{
-		"$inc":         bson.M{"score": input.Shot},
-		"$set":         bson.M{"lastPlayed": time.Now(), "name": input.Name},
-		"$setOnInsert": bson.M{"rollNumber": input.RollNumber},
+	body, _ := json.Marshal(map[string]interface{}{
+		"message": "Shot recorded successfully",
+		"outcome": outcome,
+		"score":   student.Score,
+		"ballId":  ball.ID,
+	})
+	body = append(body, '\n')
+	if key != "" {
+		// The ball may have used up ctx's deadline, so storing the response
+		// gets a fresh one. If it still fails the claim stays pending rather
+		// than being released: a retry is turned away until the claim
+		// expires instead of playing the ball a second time.
+		completeCtx, cancelComplete := context.WithTimeout(context.WithoutCancel(r.Context()), dbTimeout())
+		if err := idempotency.Complete(completeCtx, key, http.StatusOK, body); err != nil {
+			noteError(r.Context(), "Idempotency", err)
+		}
+		cancelComplete()
 	}
-	opts := options.Update().SetUpsert(true)
+	completed = true
 
-	dbStart := time.Now() // ⏱️ TIMING: DB start
-	_, err := collection.UpdateOne(ctx, filter, update, opts)
-	dbDuration := time.Since(dbStart) // ⏱️ TIMING: DB end
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// recordBall stores a ball and moves the player on the boards. On failure
+// it also returns the message for the client.
+func recordBall(ctx context.Context, ev *eventRuntime, ball BallEvent) (*Student, string, error) {
+	if hitBatcher != nil {
+		// Write-behind: durable in the journal, on the boards, in Mongo
+		// with the next flush
+		student, err := hitBatcher.Record(ctx, ev, ball)
+		return student, "Error recording shot", err
+	}
+	if hitJournal != nil {
+		// Write-through: durable in the journal first, so a store outage
+		// queues the hit instead of losing it
+		student, err := recordHit(ctx, ev, ball)
+		return student, "Error recording shot", err
+	}
 
+	// The score is written first so a failed hit leaves nothing behind and
+	// the client can retry it. Once the score has counted the hit is
+	// answered as a success even if the ball log write fails, since a
+	// retry would count it again.
+	student, err := ev.store.RecordShot(ctx, ball)
 	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, "Error updating score", http.StatusInternalServerError)
-		return
+		return nil, "Error updating score", err
+	}
+	if err := ballLog.Append(ctx, ball); err != nil {
+		noteError(ctx, "ball log append (score already counted)", err)
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Shot recorded successfully"})
+	// Move the student on the ranked boards and push to live subscribers.
+	// apply holds the event's board lock and ignores a row older than the
+	// board, so two hits whose writes finish out of order can't undo one.
+	ev.apply(*student)
+	return student, "", nil
+}
 
-	// ⏱️ TIMING LOG
-	fmt.Printf("[hitShot] Total: %v | DB: %v\n",
-		time.Since(requestStart),
-		dbDuration)
+// scoreboardQuery is the parsed form of /scoreboard's query string
+type scoreboardQuery struct {
+	limit  int
+	offset int
+	cursor *scoreboardCursor
+	around string
+	radius int
 }
 
-func getScoreboard(w http.ResponseWriter, r *http.Request) {
-	requestStart := time.Now() // ⏱️ TIMING: Request start
+// scoreboardCursor marks the last row of a page. It stores the row's sort
+// key rather than its index so pages stay stable while scores change.
+type scoreboardCursor struct {
+	Score      int    `json:"s"`
+	RollNumber string `json:"r"`
+}
+
+// ScoreboardPage is one page of /scoreboard. Only the entries go in the
+// body, as a bare array like before pagination; the total and the next
+// cursor are sent as headers.
+type ScoreboardPage struct {
+	Total      int
+	Entries    []RankedStudent
+	NextCursor string
+}
+
+// cachedPage is an encoded /scoreboard response, keyed by its query
+type cachedPage struct {
+	body      []byte
+	total     int
+	next      string // Link to the next page, if any
+	createdAt time.Time
+}
+
+func encodeCursor(row RankedStudent) string {
+	raw, _ := json.Marshal(scoreboardCursor{Score: row.Score, RollNumber: row.RollNumber})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
 
+func decodeCursor(value string) (*scoreboardCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	var cursor scoreboardCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// queryInt reads a non-negative integer parameter, falling back to def
+func queryInt(values url.Values, name string, def, max int) (int, error) {
+	raw := values.Get(name)
+	if raw == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	if n > max {
+		n = max
+	}
+	return n, nil
+}
+
+func parseScoreboardQuery(values url.Values) (scoreboardQuery, error) {
+	var q scoreboardQuery
+	var err error
+
+	if q.limit, err = queryInt(values, "limit", SCOREBOARD_DEFAULT_LIMIT, SCOREBOARD_MAX_LIMIT); err != nil {
+		return q, err
+	}
+	if q.offset, err = queryInt(values, "offset", 0, math.MaxInt32); err != nil {
+		return q, err
+	}
+	if raw := values.Get("cursor"); raw != "" {
+		if q.cursor, err = decodeCursor(raw); err != nil {
+			return q, fmt.Errorf("cursor is invalid")
+		}
+	}
+	if q.around = values.Get("around"); q.around != "" && !validateRollNumber(q.around) {
+		return q, fmt.Errorf("around must be a 10 digit roll number")
+	}
+	if q.radius, err = queryInt(values, "radius", SCOREBOARD_DEFAULT_RADIUS, SCOREBOARD_MAX_RADIUS); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+// cacheKey normalises the query so equivalent requests share a cache entry
+func (q scoreboardQuery) cacheKey() string {
+	if q.around != "" {
+		return fmt.Sprintf("around=%s&radius=%d", q.around, q.radius)
+	}
+	if q.cursor != nil {
+		return fmt.Sprintf("cursor=%d:%s&limit=%d", q.cursor.Score, q.cursor.RollNumber, q.limit)
+	}
+	return fmt.Sprintf("offset=%d&limit=%d", q.offset, q.limit)
+}
+
+// buildScoreboardPage answers a query from an event's in-memory leaderboard
+func buildScoreboardPage(board *Leaderboard, q scoreboardQuery) (ScoreboardPage, error) {
+	offset, limit := q.offset, q.limit
+	if q.around != "" {
+		position, found := board.Position(q.around)
+		if !found {
+			return ScoreboardPage{}, ErrStudentNotFound
+		}
+		offset = position - q.radius
+		if offset < 0 {
+			offset = 0
+		}
+		limit = position - offset + q.radius + 1
+	} else if q.cursor != nil {
+		offset = board.PositionAfter(q.cursor.Score, q.cursor.RollNumber)
+	}
+
+	page := ScoreboardPage{
+		Total:   board.Len(),
+		Entries: board.Range(offset, limit),
+	}
+	if q.around == "" && len(page.Entries) == limit && offset+limit < page.Total {
+		page.NextCursor = encodeCursor(page.Entries[len(page.Entries)-1])
+	}
+	return page, nil
+}
+
+// nextPageLink is the URL of the page after this one, continuing from its
+// cursor
+func nextPageLink(r *http.Request, q scoreboardQuery, cursor string) string {
+	values := url.Values{}
+	values.Set("cursor", cursor)
+	values.Set("limit", strconv.Itoa(q.limit))
+	return r.URL.Path + "?" + values.Encode()
+}
+
+// writeScoreboardPage sends a page: the rows as a JSON array, the board's
+// size in X-Total-Count and the next page, if any, in a Link header
+func writeScoreboardPage(w http.ResponseWriter, page cachedPage) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.total))
+	if page.next != "" {
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, page.next))
+	}
+	w.Write(page.body)
+}
+
+// getScoreboard serves GET /scoreboard. The body is always a JSON array of
+// ranked rows. Supported parameters:
+//
+//	limit=N                  page size (default 100, max 500)
+//	offset=N | cursor=...    where the page starts; Link: rel="next" continues it
+//	around=<roll>&radius=N   the N rows either side of one student
+func getScoreboard(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json; charset=UTF-8")
 
-	// Check if cache is valid
-	scoreboardCacheMutex.RLock()
-	if time.Since(cacheLastUpdated).Seconds() < CACHE_TTL_SECONDS && cachedScoreboard != nil {
-		json.NewEncoder(w).Encode(cachedScoreboard)
-		scoreboardCacheMutex.RUnlock()
-		fmt.Printf("[getScoreboard] Total: %v | CACHE HIT\n", time.Since(requestStart))
+	ev, ok := eventFromRequest(w, r)
+	if !ok {
 		return
 	}
-	scoreboardCacheMutex.RUnlock()
 
-	// Cache miss - fetch from database
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	q, err := parseScoreboardQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	key := ev.ID() + "?" + q.cacheKey()
 
-	// Sort by score descending
-	opts := options.Find().SetSort(bson.D{{Key: "score", Value: -1}})
+	// Check if cache is valid
+	scoreboardCacheMutex.RLock()
+	cached, hit := scoreboardCache[key]
+	scoreboardCacheMutex.RUnlock()
+	ttl := currentConfig().Scoreboard.CacheTTLSeconds
+	if hit && time.Since(cached.createdAt).Seconds() < ttl {
+		scoreboardLookups.Inc("hit")
+		writeScoreboardPage(w, cached)
+		return
+	}
+	scoreboardLookups.Inc("miss")
 
-	dbStart := time.Now() // ⏱️ TIMING: DB start
-	cursor, err := collection.Find(ctx, bson.M{}, opts)
-	if err != nil {
-		fmt.Println(err.Error())
-		http.Error(w, "Error fetching scoreboard", http.StatusInternalServerError)
+	// Cache miss - read the in-memory leaderboard, never the database
+	page, err := buildScoreboardPage(ev.leaderboard, q)
+	if errors.Is(err, ErrStudentNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Student not found"})
 		return
 	}
 
-	var students []Student
-	if err := cursor.All(ctx, &students); err != nil {
-		cursor.Close(ctx)
-		fmt.Println(err.Error())
-		http.Error(w, "Error decoding data", http.StatusInternalServerError)
+	body, err := json.Marshal(page.Entries)
+	if err != nil {
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error encoding scoreboard", http.StatusInternalServerError)
 		return
 	}
-	cursor.Close(ctx)
-	dbDuration := time.Since(dbStart) // ⏱️ TIMING: DB end
+	body = append(body, '\n')
+	fresh := cachedPage{body: body, total: page.Total, createdAt: time.Now()}
+	if page.NextCursor != "" {
+		fresh.next = nextPageLink(r, q, page.NextCursor)
+	}
 
-	// Update cache
+	// Update cache, dropping expired pages so odd queries don't pile up
 	scoreboardCacheMutex.Lock()
-	cachedScoreboard = students
-	cacheLastUpdated = time.Now()
+	for k, entry := range scoreboardCache {
+		if time.Since(entry.createdAt).Seconds() >= ttl {
+			delete(scoreboardCache, k)
+		}
+	}
+	scoreboardCache[key] = fresh
 	scoreboardCacheMutex.Unlock()
 
-	json.NewEncoder(w).Encode(students)
-
-	// ⏱️ TIMING LOG
-	fmt.Printf("[getScoreboard] Total: %v | DB: %v\n",
-		time.Since(requestStart),
-		dbDuration)
+	writeScoreboardPage(w, fresh)
 }
 
 // CORS middleware function
@@ -296,6 +612,7 @@ func enableCORS(next http.Handler) http.Handler {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "*")
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Idempotent-Replayed, X-Request-ID, X-Total-Count, Link")
 
 		// Handle preflight OPTIONS request
 		if r.Method == "OPTIONS" {
@@ -307,36 +624,149 @@ func enableCORS(next http.Handler) http.Handler {
 	})
 }
 
-func main() {
-	// Start pprof server on port 5566
-	go func() {
-		fmt.Println("pprof running on :5566")
-		log.Println(http.ListenAndServe(":5566", nil))
-	}()
+// initServer opens every store and loads the events from the current
+// config, in the order they depend on each other. Background loops run
+// until ctx ends.
+func initServer(ctx context.Context) error {
+	initBackend()
+	outcomeEngine = newOutcomeEngineFromConfig()
+	loadTeamConfig()
+	loadRosterConfig()
+	loadAdminConfig()
+	loadAuthConfig()
+	loadRateLimitConfig()
+	go sweepRateLimiters(ctx)
+	go watchConfigReload(ctx)
+	ballLog = newBallLog()
+	eventCatalog = newEventCatalog()
+	roster = newRoster()
+	joinCodes = newJoinCodeStore()
+	moderation = newModerationStore()
+	auditLog = newAuditLog()
+	idempotency = newIdempotencyStore()
+	go sweepIdempotencyKeys(ctx, idempotency)
+	if err := checkRosterTeams(ctx, teamNames); err != nil {
+		return err
+	}
 
-	initDB() // Uses MongoDB's built-in connection pooling (default: 100)
+	if err := loadEvents(ctx); err != nil {
+		return fmt.Errorf("loading events: %w", err)
+	}
+	if err := loadJournalConfig(ctx); err != nil {
+		return err
+	}
+	loadBatchConfig()
+	go resyncEvents(ctx)
+	return nil
+}
 
+// newRouter wires every route and the middleware around them
+func newRouter() http.Handler {
 	r := mux.NewRouter()
+	r.Use(annotateRequestLog, instrumentRoutes)
+
+	// API routes. The un-prefixed routes play the default event so existing
+	// clients keep working.
+	r.HandleFunc("/events", listEvents).Methods("GET", "OPTIONS")
+	r.HandleFunc("/events/{eventId}", getEventInfo).Methods("GET", "OPTIONS")
+	r.HandleFunc("/login", login).Methods("POST", "OPTIONS")
+	r.HandleFunc("/logout", logout).Methods("POST", "OPTIONS")
+	r.HandleFunc("/journal/status", getJournalStatus).Methods("GET", "OPTIONS")
+	r.HandleFunc("/metrics", getMetrics).Methods("GET")
+	r.HandleFunc("/healthz", getHealthz).Methods("GET")
+	r.HandleFunc("/readyz", getReadyz).Methods("GET")
+	for _, prefix := range []string{"", "/events/{eventId}"} {
+		r.HandleFunc(prefix+"/hit", hitShot).Methods("POST", "OPTIONS")
+		r.HandleFunc(prefix+"/scoreboard", getScoreboard).Methods("GET", "OPTIONS")
+		r.HandleFunc(prefix+"/scoreboard/stream", streamScoreboard).Methods("GET")
+		r.HandleFunc(prefix+"/students/{rollNumber}", getStudentProfile).Methods("GET", "OPTIONS")
+		r.HandleFunc(prefix+"/teams/scoreboard", getTeamScoreboard).Methods("GET", "OPTIONS")
+	}
 
-	// API routes
-	r.HandleFunc("/hit", hitShot).Methods("POST", "OPTIONS")
-	r.HandleFunc("/scoreboard", getScoreboard).Methods("GET", "OPTIONS")
+	// Admin routes, all behind ADMIN_KEY and the admin rate limit. Student and
+	// reset routes work on the default event or, under
+	// /admin/events/{eventId}, any other.
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(limitAdmin)
+	admin.HandleFunc("/roster", requireAdmin(importRoster)).Methods("POST", "OPTIONS")
+	admin.HandleFunc("/join-codes", requireAdmin(issueJoinCodesHandler)).Methods("POST", "OPTIONS")
+	admin.HandleFunc("/bans", requireAdmin(listBans)).Methods("GET", "OPTIONS")
+	admin.HandleFunc("/bans/{rollNumber}", requireAdmin(banPlayer)).Methods("POST", "OPTIONS")
+	admin.HandleFunc("/bans/{rollNumber}", requireAdmin(unbanPlayer)).Methods("DELETE")
+	admin.HandleFunc("/audit", requireAdmin(listAudit)).Methods("GET", "OPTIONS")
+	for _, prefix := range []string{"", "/events/{eventId}"} {
+		admin.HandleFunc(prefix+"/students", requireAdmin(searchStudents)).Methods("GET", "OPTIONS")
+		admin.HandleFunc(prefix+"/students/{rollNumber}", requireAdmin(deleteStudent)).Methods("DELETE", "OPTIONS")
+		admin.HandleFunc(prefix+"/students/{rollNumber}/adjust", requireAdmin(adjustScore)).Methods("POST", "OPTIONS")
+		admin.HandleFunc(prefix+"/students/{rollNumber}/rename", requireAdmin(renameStudent)).Methods("POST", "OPTIONS")
+		admin.HandleFunc(prefix+"/reset", requireAdmin(resetEvent)).Methods("POST", "OPTIONS")
+	}
 
 	// Serve static files from UI directory
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./UI")))
 
-	handler := enableCORS(r)
+	return logRequests(enableCORS(r))
+}
 
-	// Use PORT environment variable for Railway, default to 9000
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "9000"
+func main() {
+	// Offline maintenance commands take their config from CONFIG_FILE and
+	// the environment
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		mustLoadConfig(configSource{file: os.Getenv("CONFIG_FILE")})
+		switch os.Args[1] {
+		case "rebuild-scores":
+			eventID := DEFAULT_EVENT_ID
+			if len(os.Args) > 2 {
+				eventID = os.Args[2]
+			}
+			rebuildScores(eventID)
+			return
+		case "create-event":
+			createEventCommand(os.Args[2:])
+			return
+		case "archive-event":
+			archiveEventCommand(os.Args[2:])
+			return
+		case "import-roster":
+			importRosterCommand(os.Args[2:])
+			return
+		case "issue-join-codes":
+			issueJoinCodesCommand(os.Args[2:])
+			return
+		default:
+			fmt.Printf("Unknown command %q\n", os.Args[1])
+			os.Exit(2)
+		}
 	}
 
-	fmt.Printf("Cricket Battle League API running on port %s...\n", port)
+	source, printOnly, err := parseConfigFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		os.Exit(2) // The flag package has already said what was wrong
+	}
+	mustLoadConfig(source)
+	if printOnly {
+		printConfig(os.Stdout, currentConfig())
+		return
+	}
 
-	err := http.ListenAndServe(":"+port, handler)
-	if err != nil {
-		log.Fatal(err)
+	loadLogConfig()
+	logger.Info("effective config", "config", redactedConfig(currentConfig()))
+	background, stopBackground := context.WithCancel(context.Background())
+	if err := initServer(background); err != nil {
+		logger.Error("starting server", "error", err.Error())
+		os.Exit(1)
+	}
+	handler := newRouter()
+
+	// PORT is set by Railway; the default is 9000
+	port := currentConfig().Server.Port
+	logger.Info("Cricket Battle League API running", "port", port)
+	servers := []*http.Server{{Addr: ":" + port, Handler: handler}}
+	if debug := currentConfig().Debug; debug.Enabled {
+		logger.Info("debug server (pprof) running", "addr", debug.Addr)
+		servers = append(servers, &http.Server{Addr: debug.Addr, Handler: newDebugHandler()})
 	}
+	os.Exit(serve(servers, stopBackground))
 }
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestServer starts a fresh in-memory server the way main does and
+// returns its router. env overrides settings on top of the test defaults.
+func newTestServer(t *testing.T, env map[string]string) http.Handler {
+	t.Helper()
+
+	settings := map[string]string{
+		"STORE_BACKEND":      "memory",
+		"RATE_LIMIT_BACKEND": "memory",
+		"HIT_JOURNAL":        "false",
+		"JOURNAL_DIR":        t.TempDir(),
+	}
+	for name, value := range env {
+		settings[name] = value
+	}
+	for name, value := range settings {
+		t.Setenv(name, value)
+	}
+
+	// Forget whatever the previous test's server left behind
+	eventRuntimesMu.Lock()
+	eventRuntimes = make(map[string]*eventRuntime)
+	eventRuntimesMu.Unlock()
+	scoreboardCacheMutex.Lock()
+	scoreboardCache = make(map[string]cachedPage)
+	scoreboardCacheMutex.Unlock()
+	hitJournal, hitBatcher, backlog = nil, nil, &journalBacklog{}
+	journalStoreDown.Store(false)
+
+	mustLoadConfig(configSource{})
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := initServer(ctx); err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		if hitJournal != nil {
+			closeJournal(context.Background())
+		}
+	})
+	return newRouter()
+}
+
+// serveJSON sends one request through handler. A non-empty body is sent as
+// JSON.
+func serveJSON(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// tiedBoard has three students on 10, four on 5 and three on 0
+func tiedBoard() *Leaderboard {
+	board := NewLeaderboard()
+	for i, score := range []int{10, 10, 10, 5, 5, 5, 5, 0, 0, 0} {
+		board.Upsert(Student{RollNumber: fmt.Sprintf("20210000%02d", i), Score: score})
+	}
+	return board
+}
+
+func TestScoreboardCursorWithTiedScores(t *testing.T) {
+	board := tiedBoard()
+	q := scoreboardQuery{limit: 3}
+
+	var walked []RankedStudent
+	for pages := 0; ; pages++ {
+		if pages > board.Len() {
+			t.Fatal("cursor never reached the end of the board")
+		}
+		page, err := buildScoreboardPage(board, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		walked = append(walked, page.Entries...)
+		if page.NextCursor == "" {
+			break
+		}
+		if q.cursor, err = decodeCursor(page.NextCursor); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want := board.Range(0, board.Len()); !reflect.DeepEqual(walked, want) {
+		t.Fatalf("cursor walk returned %v, want %v", walked, want)
+	}
+	// Ties share a rank, also across a page boundary
+	for i, want := range []int{1, 1, 1, 4, 4, 4, 4, 8, 8, 8} {
+		if walked[i].Rank != want {
+			t.Fatalf("row %d has rank %d, want %d", i, walked[i].Rank, want)
+		}
+	}
+}
+
+func TestScoreboardCursorSurvivesTiedMoves(t *testing.T) {
+	board := tiedBoard()
+	first, _ := buildScoreboardPage(board, scoreboardQuery{limit: 4})
+	cursor, _ := decodeCursor(first.NextCursor)
+
+	// The last row of the first page scores, so its old sort key no longer
+	// exists; the next page must still start right after it
+	moved := first.Entries[3].Student
+	moved.Score += 1
+	board.Upsert(moved)
+
+	next, err := buildScoreboardPage(board, scoreboardQuery{limit: 4, cursor: cursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := next.Entries[0].RollNumber; got != "2021000004" {
+		t.Fatalf("next page starts at %s, want 2021000004", got)
+	}
+}
+
+func TestScoreboardAroundWithTiedScores(t *testing.T) {
+	board := tiedBoard()
+	page, err := buildScoreboardPage(board, scoreboardQuery{around: "2021000005", radius: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rolls []string
+	for _, row := range page.Entries {
+		rolls = append(rolls, row.RollNumber)
+		if row.Score == 5 && row.Rank != 4 {
+			t.Fatalf("%s has rank %d, want the shared rank 4", row.RollNumber, row.Rank)
+		}
+	}
+	want := []string{"2021000003", "2021000004", "2021000005", "2021000006", "2021000007"}
+	if !reflect.DeepEqual(rolls, want) {
+		t.Fatalf("around returned %v, want %v", rolls, want)
+	}
+	if page.NextCursor != "" {
+		t.Fatal("around query returned a next cursor")
+	}
+
+	// Near the top the window is cut off rather than shifted
+	page, _ = buildScoreboardPage(board, scoreboardQuery{around: "2021000000", radius: 2})
+	if len(page.Entries) != 3 {
+		t.Fatalf("around the leader returned %d rows, want 3", len(page.Entries))
+	}
+
+	if _, err := buildScoreboardPage(board, scoreboardQuery{around: "2021999999", radius: 2}); !errors.Is(err, ErrStudentNotFound) {
+		t.Fatalf("around an unknown student returned %v, want ErrStudentNotFound", err)
+	}
+}
+
+// /scoreboard keeps its original body, a bare array; paging rides in the
+// X-Total-Count and Link headers
+func TestScoreboardPagesThroughHeaders(t *testing.T) {
+	server := newTestServer(t, nil)
+	ev, _ := getEvent(DEFAULT_EVENT_ID)
+	for i := 0; i < 5; i++ {
+		ev.apply(Student{RollNumber: fmt.Sprintf("20210000%02d", i), Score: 5})
+	}
+
+	rec := serveJSON(server, "GET", "/scoreboard?limit=3", "")
+	var rows []RankedStudent
+	if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+		t.Fatalf("body is not an array: %v", err)
+	}
+	if len(rows) != 3 || rec.Header().Get("X-Total-Count") != "5" {
+		t.Fatalf("got %d rows of %s, want 3 of 5", len(rows), rec.Header().Get("X-Total-Count"))
+	}
+	link := rec.Header().Get("Link")
+	if !strings.HasPrefix(link, "</scoreboard?cursor=") || !strings.HasSuffix(link, `>; rel="next"`) {
+		t.Fatalf("Link is %q", link)
+	}
+
+	next := strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+	rec = serveJSON(server, "GET", next, "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].RollNumber != "2021000003" || rec.Header().Get("Link") != "" {
+		t.Fatalf("second page is %v with Link %q", rows, rec.Header().Get("Link"))
+	}
+
+	rec = serveJSON(server, "GET", "/scoreboard", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil || len(rows) != 5 {
+		t.Fatalf("unparameterised scoreboard returned %s", rec.Body.String())
+	}
+}
+
+func TestHitRejectsBadInput(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"not json", `{"rollNumber":`, http.StatusBadRequest},
+		{"short roll number", `{"rollNumber":"12345","name":"Asha"}`, http.StatusBadRequest},
+		{"letters in roll number", `{"rollNumber":"20210000ab","name":"Asha"}`, http.StatusBadRequest},
+		{"no name", `{"rollNumber":"2021000001"}`, http.StatusBadRequest},
+		{"blank name", `{"rollNumber":"2021000001","name":"   "}`, http.StatusBadRequest},
+		{"markup only", `{"rollNumber":"2021000001","name":"<>"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serveJSON(server, "POST", "/hit", tt.body); rec.Code != tt.want {
+				t.Errorf("got %d %s, want %d", rec.Code, rec.Body.String(), tt.want)
+			}
+		})
+	}
+
+	ev, _ := getEvent(DEFAULT_EVENT_ID)
+	if ev.leaderboard.Len() != 0 {
+		t.Errorf("rejected hits put %d players on the board", ev.leaderboard.Len())
+	}
+}
+
+func TestHitReachesScoreboardAndProfile(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	scores := make(map[string]int)
+	for _, roll := range []string{"2021000001", "2021000002", "2021000003"} {
+		rec := serveJSON(server, "POST", "/hit", `{"rollNumber":"`+roll+`","name":"Player `+roll[len(roll)-1:]+`"}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("hit %s: %d %s", roll, rec.Code, rec.Body.String())
+		}
+		var hit struct {
+			Message string `json:"message"`
+			Outcome struct {
+				Result string `json:"result"`
+				Runs   int    `json:"runs"`
+			} `json:"outcome"`
+			Score  int    `json:"score"`
+			BallID string `json:"ballId"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &hit); err != nil {
+			t.Fatal(err)
+		}
+		if hit.Outcome.Result == "" || hit.BallID == "" || hit.Score != hit.Outcome.Runs {
+			t.Fatalf("first hit of %s answered %s", roll, rec.Body.String())
+		}
+		scores[roll] = hit.Score
+	}
+
+	rec := serveJSON(server, "GET", "/scoreboard", "")
+	var rows []RankedStudent
+	if err := json.Unmarshal(rec.Body.Bytes(), &rows); err != nil {
+		t.Fatalf("scoreboard: %v in %s", err, rec.Body.String())
+	}
+	if len(rows) != len(scores) || rec.Header().Get("X-Total-Count") != fmt.Sprint(len(scores)) {
+		t.Fatalf("scoreboard has %d rows, total %q, want %d", len(rows), rec.Header().Get("X-Total-Count"), len(scores))
+	}
+	for i, row := range rows {
+		if row.Score != scores[row.RollNumber] || row.BallsFaced != 1 {
+			t.Errorf("row %+v, want score %d after 1 ball", row, scores[row.RollNumber])
+		}
+		if i > 0 && row.Score > rows[i-1].Score {
+			t.Errorf("rows out of order: %d after %d", row.Score, rows[i-1].Score)
+		}
+	}
+
+	for _, row := range rows {
+		rec := serveJSON(server, "GET", "/students/"+row.RollNumber, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("profile %s: %d %s", row.RollNumber, rec.Code, rec.Body.String())
+		}
+		var profile StudentProfile
+		if err := json.Unmarshal(rec.Body.Bytes(), &profile); err != nil {
+			t.Fatal(err)
+		}
+		if profile.Rank != row.Rank || profile.Score != row.Score || profile.TotalPlayers != len(rows) {
+			t.Errorf("profile %+v does not match scoreboard row %+v", profile, row)
+		}
+		if (profile.GapToAbove == nil) != (row.Score == rows[0].Score) {
+			t.Errorf("profile of %s has gap %v with the top score %d", row.RollNumber, profile.GapToAbove, rows[0].Score)
+		}
+	}
+
+	if rec := serveJSON(server, "GET", "/students/2021000099", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown player: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := serveJSON(server, "GET", "/students/abc", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad roll number: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestProfileOfUnrankedStudent(t *testing.T) {
+	server := newTestServer(t, nil)
+	ev, _ := getEvent(DEFAULT_EVENT_ID)
+	ball := newBallEvent(ev.ID(), "2021000001", "Asha", "", ShotOutcome{Result: "6", Runs: 6}, "", "")
+	student, err := ev.store.RecordShot(context.Background(), ball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev.apply(*student)
+
+	// In the store but not on this board, as after a hit on another instance
+	ball = newBallEvent(ev.ID(), "2021000002", "Ravi", "", ShotOutcome{Result: "4", Runs: 4}, "", "")
+	if _, err := ev.store.RecordShot(context.Background(), ball); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serveJSON(server, "GET", "/students/2021000002", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("profile: %d %s", rec.Code, rec.Body.String())
+	}
+	var profile map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &profile); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := profile["rank"]; ok {
+		t.Errorf("unranked student has rank %v", profile["rank"])
+	}
+	if _, ok := profile["percentile"]; ok {
+		t.Errorf("unranked student has percentile %v", profile["percentile"])
+	}
+	if profile["score"] != 4.0 {
+		t.Errorf("score = %v, want 4", profile["score"])
+	}
+
+	rec = serveJSON(server, "GET", "/students/2021000001", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile["rank"] != 1.0 || profile["percentile"] != 100.0 {
+		t.Errorf("ranked student: rank %v, percentile %v", profile["rank"], profile["percentile"])
+	}
+}
+
+// jitteryStore answers RecordShot after a random delay, so writes for one
+// player finish out of order
+type jitteryStore struct {
+	ScoreStore
+}
+
+func (s *jitteryStore) RecordShot(ctx context.Context, ball BallEvent) (*Student, error) {
+	student, err := s.ScoreStore.RecordShot(ctx, ball)
+	time.Sleep(time.Duration(rand.Intn(2000)) * time.Microsecond)
+	return student, err
+}
+
+// Concurrent hits for one player in a second event go through the direct
+// store path; the board must end on the store's final row
+func TestConcurrentHitsKeepTheBoardCurrent(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"RATE_LIMIT_ROLL_BURST": "1000",
+		"RATE_LIMIT_IP_BURST":   "1000",
+	})
+	ctx := context.Background()
+	if err := eventCatalog.Save(ctx, Event{ID: "spring", Name: "Spring", StartsAt: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadEvents(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ev, _ := getEvent("spring")
+	ev.store = &jitteryStore{ScoreStore: ev.store}
+
+	const hits = 40
+	var wg sync.WaitGroup
+	for i := 0; i < hits; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveJSON(server, "POST", "/events/spring/hit", `{"rollNumber":"2021000001","name":"Asha"}`)
+		}()
+	}
+	wg.Wait()
+
+	stored, err := ev.store.GetStudent(ctx, "2021000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	onBoard, _ := ev.leaderboard.Get("2021000001")
+	if stored.BallsFaced != hits || onBoard.BallsFaced != hits || onBoard.Score != stored.Score {
+		t.Errorf("store has %d balls and %d runs, board %d balls and %d runs, want %d balls",
+			stored.BallsFaced, stored.Score, onBoard.BallsFaced, onBoard.Score, hits)
+	}
+}
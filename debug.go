@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DEBUG_DEFAULT_ADDR      = "127.0.0.1:5566" // Loopback, so only the host itself can reach it
+	DEBUG_MIN_TOKEN_LENGTH  = 16
+	DEBUG_LIMITER_MAX_KEYS  = 100 // Buckets listed per limiter on /debug/limiters
+	DEBUG_CACHE_MAX_ENTRIES = 200
+)
+
+// Process start, for the uptime on /debug/runtime
+var processStart = time.Now()
+
+// newDebugHandler serves pprof and this server's own debug pages. It only
+// runs with DEBUG_ENABLED=true, on DEBUG_ADDR, and every request needs
+// DEBUG_TOKEN.
+func newDebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index) // Also serves heap, goroutine, allocs, ...
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("/debug/", getDebugIndex)
+	mux.HandleFunc("/debug/runtime", getDebugRuntime)
+	mux.HandleFunc("/debug/limiters", getDebugLimiters)
+	mux.HandleFunc("/debug/cache", getDebugCache)
+	mux.HandleFunc("/debug/config", getDebugConfig)
+
+	return logRequests(requireDebugToken(mux))
+}
+
+// requireDebugToken accepts "Authorization: Bearer <DEBUG_TOKEN>" only. A
+// query parameter would end up in access logs and browser history; fetch
+// profiles with curl and the header, then open the file with go tool pprof.
+func requireDebugToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			token = strings.TrimPrefix(header, "Bearer ")
+		}
+		want := currentConfig().Debug.Token
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="debug"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Debug token required"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeDebugJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// getDebugIndex lists the debug pages
+func getDebugIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/debug/" {
+		http.NotFound(w, r)
+		return
+	}
+	writeDebugJSON(w, map[string]string{
+		"/debug/pprof/":   "Go profiles (curl -H 'Authorization: Bearer ...' -o heap.pb.gz http://HOST/debug/pprof/heap, then go tool pprof heap.pb.gz)",
+		"/debug/runtime":  "Goroutines, memory, streams and journal state",
+		"/debug/limiters": "Rate limiter policies and the emptiest buckets",
+		"/debug/cache":    "Scoreboard cache entries",
+		"/debug/config":   "Effective config, secrets redacted",
+	})
+}
+
+// getDebugRuntime reports goroutines and memory next to the server's own
+// moving parts
+func getDebugRuntime(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	events := make(map[string]map[string]int)
+	eventRuntimesMu.RLock()
+	for id, ev := range eventRuntimes {
+		events[id] = map[string]int{
+			"students":      ev.leaderboard.Len(),
+			"streamClients": ev.hub.clientCount(),
+		}
+	}
+	eventRuntimesMu.RUnlock()
+
+	view := map[string]interface{}{
+		"uptimeSeconds": int(time.Since(processStart).Seconds()),
+		"goroutines":    runtime.NumGoroutine(),
+		"gomaxprocs":    runtime.GOMAXPROCS(0),
+		"goVersion":     runtime.Version(),
+		"memory": map[string]uint64{
+			"heapAllocBytes": mem.HeapAlloc,
+			"heapInuseBytes": mem.HeapInuse,
+			"sysBytes":       mem.Sys,
+			"numGC":          uint64(mem.NumGC),
+		},
+		"events": events,
+	}
+	if hitJournal != nil {
+		view["journal"] = map[string]interface{}{
+			"status":    hitJournal.Status(),
+			"backlog":   backlog.Len(),
+			"storeDown": journalStoreDown.Load(),
+		}
+	}
+	if hitBatcher != nil {
+		pending, failed := hitBatcher.Pending()
+		view["batcher"] = map[string]int{"pendingHits": pending, "failedBatches": failed}
+	}
+	writeDebugJSON(w, view)
+}
+
+// limiterView is one limiter on /debug/limiters. Mongo limiters keep their
+// buckets in the database, so only the policy is shown.
+type limiterView struct {
+	Backend string          `json:"backend"`
+	Policy  RateLimitPolicy `json:"policy"`
+	Keys    int             `json:"keys,omitempty"`
+	Buckets []bucketView    `json:"emptiestBuckets,omitempty"`
+}
+
+func getDebugLimiters(w http.ResponseWriter, r *http.Request) {
+	views := make(map[string]limiterView)
+	for name, limiter := range map[string]RateLimiter{"roll": rollLimiter, "ip": ipLimiter, "login": loginLimiter, "admin": adminLimiter} {
+		switch l := limiter.(type) {
+		case *TokenBucketLimiter:
+			policy, keys, buckets := l.Snapshot(DEBUG_LIMITER_MAX_KEYS)
+			views[name] = limiterView{Backend: "memory", Policy: policy, Keys: keys, Buckets: buckets}
+		case *mongoRateLimiter:
+			policy, _ := l.currentPolicy()
+			views[name] = limiterView{Backend: "mongo", Policy: policy}
+		}
+	}
+	writeDebugJSON(w, views)
+}
+
+// cacheEntryView is one scoreboard page on /debug/cache
+type cacheEntryView struct {
+	Key        string  `json:"key"`
+	Bytes      int     `json:"bytes"`
+	AgeSeconds float64 `json:"ageSeconds"`
+	Expired    bool    `json:"expired"`
+}
+
+func getDebugCache(w http.ResponseWriter, r *http.Request) {
+	ttl := currentConfig().Scoreboard.CacheTTLSeconds
+
+	scoreboardCacheMutex.RLock()
+	entries := make([]cacheEntryView, 0, len(scoreboardCache))
+	totalBytes := 0
+	for key, page := range scoreboardCache {
+		age := time.Since(page.createdAt).Seconds()
+		entries = append(entries, cacheEntryView{Key: key, Bytes: len(page.body), AgeSeconds: age, Expired: age >= ttl})
+		totalBytes += len(page.body)
+	}
+	scoreboardCacheMutex.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].AgeSeconds < entries[j].AgeSeconds })
+	count := len(entries)
+	if count > DEBUG_CACHE_MAX_ENTRIES {
+		entries = entries[:DEBUG_CACHE_MAX_ENTRIES]
+	}
+	writeDebugJSON(w, map[string]interface{}{
+		"ttlSeconds": ttl,
+		"entries":    count,
+		"bytes":      totalBytes,
+		"newest":     entries,
+	})
+}
+
+func getDebugConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	printConfig(w, currentConfig())
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testDebugToken = "debug-token-0123456789"
+
+func TestRequireDebugToken(t *testing.T) {
+	newTestServer(t, map[string]string{
+		"DEBUG_ENABLED": "true",
+		"DEBUG_TOKEN":   testDebugToken,
+	})
+	handler := newDebugHandler()
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		want          int
+	}{
+		{"no token", "/debug/runtime", "", http.StatusUnauthorized},
+		{"wrong token", "/debug/runtime", "Bearer not-the-token", http.StatusUnauthorized},
+		{"empty bearer", "/debug/runtime", "Bearer ", http.StatusUnauthorized},
+		{"token without the scheme", "/debug/runtime", testDebugToken, http.StatusUnauthorized},
+		{"query parameter", "/debug/runtime?token=" + testDebugToken, "", http.StatusUnauthorized},
+		{"bearer token", "/debug/runtime", "Bearer " + testDebugToken, http.StatusOK},
+		{"bearer token on pprof", "/debug/pprof/", "Bearer " + testDebugToken, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: 401 without WWW-Authenticate", tt.name)
+		}
+	}
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DEFAULT_EVENT_ID       = "default" // Serves the legacy /hit and /scoreboard routes
+	EVENTS_RESYNC_SECONDS  = 60        // Reload events and boards to pick up other instances' changes
+	EVENTS_LOAD_TIMEOUT_MS = 30000
+)
+
+// ErrEventNotFound is returned for an unknown event ID
+var ErrEventNotFound = errors.New("event not found")
+
+var eventIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+
+// Event is one fest or season with its own leaderboard. Once it ends or is
+// archived its scores are kept but no more hits are accepted.
+type Event struct {
+	ID       string     `json:"id" bson:"_id"`
+	Name     string     `json:"name" bson:"name"`
+	StartsAt time.Time  `json:"startsAt" bson:"startsAt"`
+	EndsAt   *time.Time `json:"endsAt,omitempty" bson:"endsAt,omitempty"` // Nil means open-ended
+	Archived bool       `json:"archived" bson:"archived"`
+}
+
+// Status is "upcoming", "live" or "archived"
+func (e Event) Status(now time.Time) string {
+	switch {
+	case e.Archived || (e.EndsAt != nil && !now.Before(*e.EndsAt)):
+		return "archived"
+	case now.Before(e.StartsAt):
+		return "upcoming"
+	default:
+		return "live"
+	}
+}
+
+// EventCatalog stores event metadata
+type EventCatalog interface {
+	List(ctx context.Context) ([]Event, error)
+	// Save creates or replaces an event
+	Save(ctx context.Context, event Event) error
+}
+
+// eventRuntime is everything the server holds for one loaded event
+type eventRuntime struct {
+	mu        sync.RWMutex
+	event     Event
+	cancelHub context.CancelFunc // Stops the hub; nil while it is not running
+
+	store       ScoreStore
+	leaderboard *Leaderboard // Reads never touch the database; hitShot keeps this up to date
+	teams       *TeamBoard
+	hub         *scoreboardHub
+
+	boardMu sync.Mutex // Serialises changes to a student on the boards
+
+	unstoredMu sync.Mutex
+	unstored   int    // Hits on the boards the store does not have yet (batched or queued)
+	projected  uint64 // Every such hit so far, so refresh can tell if one came in while it read
+}
+
+func (ev *eventRuntime) ID() string {
+	return ev.Event().ID
+}
+
+func (ev *eventRuntime) Event() Event {
+	ev.mu.RLock()
+	defer ev.mu.RUnlock()
+	return ev.event
+}
+
+func (ev *eventRuntime) setEvent(event Event) {
+	ev.mu.Lock()
+	ev.event = event
+	ev.mu.Unlock()
+}
+
+// startHub runs the live scoreboard hub until stopHub is called or ctx
+// ends. It does nothing if the hub is already running.
+func (ev *eventRuntime) startHub(ctx context.Context) {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	if ev.cancelHub != nil {
+		return
+	}
+	ctx, ev.cancelHub = context.WithCancel(ctx)
+	go ev.hub.Run(ctx)
+}
+
+// stopHub stops the live scoreboard hub. Subscribers keep their stream but
+// get no more diffs.
+func (ev *eventRuntime) stopHub() {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	if ev.cancelHub != nil {
+		ev.cancelHub()
+		ev.cancelHub = nil
+	}
+}
+
+// reload refills the leaderboard from the store
+func (ev *eventRuntime) reload(ctx context.Context) error {
+	students, err := ev.readBoard(ctx)
+	if err != nil {
+		return err
+	}
+	ev.load(students)
+	return nil
+}
+
+// refresh is reload for a board that may show hits the store does not
+// have yet; loading the store's rows would take those off the board until
+// the next reload. It does nothing while any are outstanding, or if one
+// came in while the store was being read.
+func (ev *eventRuntime) refresh(ctx context.Context) error {
+	ev.unstoredMu.Lock()
+	mark, settled := ev.projected, ev.unstored == 0
+	ev.unstoredMu.Unlock()
+	if !settled {
+		return nil
+	}
+
+	students, err := ev.readBoard(ctx)
+	if err != nil {
+		return err
+	}
+	ev.unstoredMu.Lock()
+	defer ev.unstoredMu.Unlock()
+	if ev.projected == mark {
+		ev.load(students)
+	}
+	return nil
+}
+
+func (ev *eventRuntime) readBoard(ctx context.Context) ([]Student, error) {
+	start := time.Now()
+	students, err := ev.store.Leaderboard(ctx)
+	observeDB("load_leaderboard", start, err)
+	return students, err
+}
+
+func (ev *eventRuntime) load(students []Student) {
+	ev.leaderboard.Load(students)
+	ev.teams.Load(students)
+	ev.hub.Notify()
+}
+
+// hasUnstored reports whether the boards show hits the store does not
+// have yet
+func (ev *eventRuntime) hasUnstored() bool {
+	ev.unstoredMu.Lock()
+	defer ev.unstoredMu.Unlock()
+	return ev.unstored > 0
+}
+
+// stored records that n projected hits made it into the store
+func (ev *eventRuntime) stored(n int) {
+	ev.unstoredMu.Lock()
+	ev.unstored -= n
+	ev.unstoredMu.Unlock()
+}
+
+// apply moves an updated student on the individual and team boards and
+// wakes live subscribers. Store writes for one player can finish out of
+// order, so a row with fewer balls than the board already shows is older
+// than it and is dropped.
+func (ev *eventRuntime) apply(student Student) {
+	ev.boardMu.Lock()
+	defer ev.boardMu.Unlock()
+	if before, existed := ev.leaderboard.Get(student.RollNumber); existed && student.BallsFaced < before.BallsFaced {
+		return
+	}
+	ev.applyLocked(student)
+}
+
+// applyLocked is apply for callers already holding boardMu, and without the
+// staleness check
+func (ev *eventRuntime) applyLocked(student Student) {
+	before, existed := ev.leaderboard.Get(student.RollNumber)
+	ev.leaderboard.Upsert(student)
+	ev.teams.Apply(student)
+	if existed {
+		ev.hub.Changed(&before, &student)
+	} else {
+		ev.hub.Changed(nil, &student)
+	}
+}
+
+// project adds a ball the store has not taken yet to the student on the
+// boards. The ball counts as unstored until stored is called for it.
+func (ev *eventRuntime) project(ball BallEvent) Student {
+	// Counted first, so a refresh that loads before the ball is shown
+	// knows to drop its rows
+	ev.unstoredMu.Lock()
+	ev.unstored++
+	ev.projected++
+	ev.unstoredMu.Unlock()
+
+	ev.boardMu.Lock()
+	defer ev.boardMu.Unlock()
+	student, exists := ev.leaderboard.Get(ball.RollNumber)
+	if !exists {
+		student = Student{RollNumber: ball.RollNumber, Team: ball.Team}
+	}
+	student.applyOutcome(ball.Shot)
+	student.Name = ball.Name
+	student.LastPlayed = ball.Timestamp
+	ev.applyLocked(student)
+	return student
+}
+
+// change makes an admin change to the student on the boards rather than
+// copying the store's row, which misses hits still on their way to the
+// store. A student not on the boards gets the row.
+func (ev *eventRuntime) change(row Student, change func(*Student)) Student {
+	ev.boardMu.Lock()
+	defer ev.boardMu.Unlock()
+	student, exists := ev.leaderboard.Get(row.RollNumber)
+	if !exists {
+		student = row
+	} else {
+		change(&student)
+	}
+	ev.applyLocked(student)
+	return student
+}
+
+// remove takes a deleted student off both boards
+func (ev *eventRuntime) remove(rollNumber string) {
+	ev.boardMu.Lock()
+	defer ev.boardMu.Unlock()
+	before, existed := ev.leaderboard.Get(rollNumber)
+	ev.leaderboard.Remove(rollNumber)
+	ev.teams.Remove(rollNumber)
+	if existed {
+		ev.hub.Changed(&before, nil)
+	}
+}
+
+var (
+	eventCatalog EventCatalog
+
+	// Loaded events by ID
+	eventRuntimes   = make(map[string]*eventRuntime)
+	eventRuntimesMu sync.RWMutex
+)
+
+// newEventCatalog opens the event metadata, one document per event ID
+func newEventCatalog() EventCatalog {
+	if events := openCollection("events"); events != nil {
+		return &mongoEventCatalog{collection: events}
+	}
+	return &memoryEventCatalog{events: make(map[string]Event)}
+}
+
+// getEvent returns a loaded event
+func getEvent(id string) (*eventRuntime, bool) {
+	eventRuntimesMu.RLock()
+	defer eventRuntimesMu.RUnlock()
+	ev, ok := eventRuntimes[id]
+	return ev, ok
+}
+
+// eventFromRequest resolves the {eventId} route variable, defaulting to the
+// default event for the legacy un-prefixed routes. On failure it writes the
+// error response and returns false.
+func eventFromRequest(w http.ResponseWriter, r *http.Request) (*eventRuntime, bool) {
+	id := mux.Vars(r)["eventId"]
+	if id == "" {
+		id = DEFAULT_EVENT_ID
+	}
+	ev, ok := getEvent(id)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Event not found"})
+		return nil, false
+	}
+	return ev, true
+}
+
+// loadEvents makes sure the default event exists, then loads every event in
+// the catalog. Already loaded events get fresh metadata and, unless they
+// are archived, a fresh leaderboard. Hubs of events that are not archived
+// run until ctx ends; an event that gets archived has its hub stopped.
+func loadEvents(ctx context.Context) error {
+	readCtx, cancel := context.WithTimeout(ctx, EVENTS_LOAD_TIMEOUT_MS*time.Millisecond)
+	defer cancel()
+
+	events, err := eventCatalog.List(readCtx)
+	if err != nil {
+		return err
+	}
+	if !containsEvent(events, DEFAULT_EVENT_ID) {
+		def := Event{ID: DEFAULT_EVENT_ID, Name: "Cricket Battle League", StartsAt: time.Now()}
+		if err := eventCatalog.Save(readCtx, def); err != nil {
+			return err
+		}
+		events = append(events, def)
+	}
+
+	now := time.Now()
+	for _, event := range events {
+		archived := event.Status(now) == "archived"
+		if ev, loaded := getEvent(event.ID); loaded {
+			ev.setEvent(event)
+			if archived {
+				ev.stopHub()
+				continue
+			}
+			ev.startHub(ctx)
+			if err := ev.refresh(readCtx); err != nil {
+				logger.Error("reloading event failed", "event", event.ID, "error", err.Error())
+			}
+			continue
+		}
+
+		board := NewLeaderboard()
+		ev := &eventRuntime{
+			event:       event,
+			store:       newScoreStore(event.ID),
+			leaderboard: board,
+			teams:       NewTeamBoard(),
+			hub:         newScoreboardHub(board),
+		}
+		if err := ev.reload(readCtx); err != nil {
+			return fmt.Errorf("loading event %s: %w", event.ID, err)
+		}
+		if !archived {
+			ev.startHub(ctx)
+		}
+
+		eventRuntimesMu.Lock()
+		eventRuntimes[event.ID] = ev
+		eventRuntimesMu.Unlock()
+		logger.Info("loaded event", "event", event.ID, "status", event.Status(now), "students", board.Len())
+	}
+	return nil
+}
+
+// resyncEvents periodically reloads events so events created or archived
+// from the CLI, and hits recorded by other instances, show up here too
+func resyncEvents(ctx context.Context) {
+	ticker := time.NewTicker(EVENTS_RESYNC_SECONDS * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := loadEvents(ctx); err != nil {
+				logger.Error("event resync failed", "error", err.Error())
+			}
+		}
+	}
+}
+
+func containsEvent(events []Event, id string) bool {
+	for _, event := range events {
+		if event.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// eventView is an Event as returned by the API
+type eventView struct {
+	Event
+	Status  string `json:"status"`
+	Players int    `json:"players"`
+}
+
+func viewEvent(ev *eventRuntime) eventView {
+	event := ev.Event()
+	return eventView{Event: event, Status: event.Status(time.Now()), Players: ev.leaderboard.Len()}
+}
+
+// listEvents serves GET /events, newest first
+func listEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json; charset=UTF-8")
+
+	eventRuntimesMu.RLock()
+	views := make([]eventView, 0, len(eventRuntimes))
+	for _, ev := range eventRuntimes {
+		views = append(views, viewEvent(ev))
+	}
+	eventRuntimesMu.RUnlock()
+
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].StartsAt.After(views[j].StartsAt)
+	})
+	json.NewEncoder(w).Encode(views)
+}
+
+// getEventInfo serves GET /events/{eventId}
+func getEventInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json; charset=UTF-8")
+
+	ev, ok := eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+	json.NewEncoder(w).Encode(viewEvent(ev))
+}
+
+// ---------------------------------------------------------------------------
+// CLI
+// ---------------------------------------------------------------------------
+
+// createEventCommand runs `cricket create-event -id fest-2026 -name "..."
+// [-start RFC3339] [-end RFC3339]`. Running servers pick it up on their next
+// resync.
+func createEventCommand(args []string) {
+	flags := flag.NewFlagSet("create-event", flag.ExitOnError)
+	id := flags.String("id", "", "event ID (lowercase letters, digits and dashes)")
+	name := flags.String("name", "", "display name")
+	start := flags.String("start", "", "start time, RFC3339 (default now)")
+	end := flags.String("end", "", "end time, RFC3339 (default open-ended)")
+	flags.Parse(args)
+
+	if !eventIDPattern.MatchString(*id) {
+		fmt.Println("-id must be 1-40 lowercase letters, digits or dashes")
+		os.Exit(2)
+	}
+	if *name == "" {
+		fmt.Println("-name is required")
+		os.Exit(2)
+	}
+
+	event := Event{ID: *id, Name: *name, StartsAt: time.Now()}
+	var err error
+	if *start != "" {
+		if event.StartsAt, err = time.Parse(time.RFC3339, *start); err != nil {
+			fmt.Println("-start:", err.Error())
+			os.Exit(2)
+		}
+	}
+	if *end != "" {
+		endsAt, err := time.Parse(time.RFC3339, *end)
+		if err != nil {
+			fmt.Println("-end:", err.Error())
+			os.Exit(2)
+		}
+		if !endsAt.After(event.StartsAt) {
+			fmt.Println("-end must be after -start")
+			os.Exit(2)
+		}
+		event.EndsAt = &endsAt
+	}
+
+	initBackend()
+	eventCatalog = newEventCatalog()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := eventCatalog.Save(ctx, event); err != nil {
+		fmt.Println("Saving event:", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("Saved event %s (%s)\n", event.ID, event.Name)
+}
+
+// archiveEventCommand runs `cricket archive-event <eventId>`, making the
+// event read-only
+func archiveEventCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Println("usage: cricket archive-event <eventId>")
+		os.Exit(2)
+	}
+
+	initBackend()
+	eventCatalog = newEventCatalog()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	events, err := eventCatalog.List(ctx)
+	if err != nil {
+		fmt.Println("Listing events:", err.Error())
+		os.Exit(1)
+	}
+	for _, event := range events {
+		if event.ID != args[0] {
+			continue
+		}
+		event.Archived = true
+		if err := eventCatalog.Save(ctx, event); err != nil {
+			fmt.Println("Saving event:", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Archived event %s\n", event.ID)
+		return
+	}
+	fmt.Println(ErrEventNotFound.Error())
+	os.Exit(1)
+}
+
+// ---------------------------------------------------------------------------
+// MongoDB catalog
+// ---------------------------------------------------------------------------
+
+type mongoEventCatalog struct {
+	collection *mongo.Collection
+}
+
+func (c *mongoEventCatalog) List(ctx context.Context) ([]Event, error) {
+	cursor, err := c.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var events []Event
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (c *mongoEventCatalog) Save(ctx context.Context, event Event) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := c.collection.ReplaceOne(ctx, bson.M{"_id": event.ID}, event, opts)
+	return err
+}
+
+// ---------------------------------------------------------------------------
+// In-memory catalog
+// ---------------------------------------------------------------------------
+
+type memoryEventCatalog struct {
+	mu     sync.RWMutex
+	events map[string]Event
+}
+
+func (c *memoryEventCatalog) List(ctx context.Context) ([]Event, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	events := make([]Event, 0, len(c.events))
+	for _, event := range c.events {
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (c *memoryEventCatalog) Save(ctx context.Context, event Event) error {
+	c.mu.Lock()
+	c.events[event.ID] = event
+	c.mu.Unlock()
+	return nil
+}
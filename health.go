@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	READY_PING_TIMEOUT_MS         = 2000
+	READY_DEFAULT_MAX_JOURNAL_LAG = 10000
+)
+
+// readyCheck is one line of the /readyz breakdown
+type readyCheck struct {
+	Status    string      `json:"status"` // "ok", "degraded", "failing" or "skipped"
+	Error     string      `json:"error,omitempty"`
+	LatencyMs float64     `json:"latencyMs,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+// getHealthz serves GET /healthz: the process is up and serving
+func getHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// getReadyz serves GET /readyz: 200 if this instance should get traffic,
+// "degraded" if it can take hits but something is down, 503 with the
+// failing checks if not
+func getReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), READY_PING_TIMEOUT_MS*time.Millisecond)
+	defer cancel()
+
+	checks := map[string]readyCheck{
+		"mongo":   checkMongo(ctx),
+		"journal": checkJournal(),
+		"batcher": checkBatcher(),
+		"store":   checkStoreWarm(),
+	}
+
+	ready, degraded := true, false
+	for _, check := range checks {
+		switch check.Status {
+		case "failing":
+			ready = false
+		case "degraded":
+			degraded = true
+		}
+	}
+	if draining.Load() {
+		ready = false
+		checks["shutdown"] = readyCheck{Status: "failing", Error: "shutting down"}
+	}
+
+	status := "ready"
+	if degraded {
+		status = "degraded"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !ready {
+		status = "not ready"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": checks})
+}
+
+// checkMongo pings the database. With the hit journal on, hits are still
+// accepted while Mongo is down, so the instance stays in rotation.
+func checkMongo(ctx context.Context) readyCheck {
+	if mongoClient == nil {
+		return readyCheck{Status: "skipped"}
+	}
+	start := time.Now()
+	err := mongoClient.Ping(ctx, nil)
+	check := readyCheck{Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		noteError(ctx, "Mongo ping", err)
+		check.Status = "failing"
+		check.Error = "ping failed"
+		if hitJournal != nil {
+			check.Status = "degraded"
+			check.Error = "ping failed, hits wait in the journal"
+		}
+	}
+	return check
+}
+
+// checkJournal fails once the store is so far behind that the backlog is
+// better drained than added to
+func checkJournal() readyCheck {
+	if hitJournal == nil {
+		return readyCheck{Status: "skipped"}
+	}
+	status := hitJournal.Status()
+	check := readyCheck{Status: "ok", Details: status}
+	if status.Lag > uint64(currentConfig().Journal.ReadyMaxLag) {
+		check.Status = "failing"
+		check.Error = "journal lag over READY_MAX_JOURNAL_LAG"
+	}
+	return check
+}
+
+func checkBatcher() readyCheck {
+	if hitBatcher == nil {
+		return readyCheck{Status: "skipped"}
+	}
+	pending, failed := hitBatcher.Pending()
+	check := readyCheck{Status: "ok", Details: map[string]int{"pendingHits": pending, "failedBatches": failed}}
+	select {
+	case <-hitBatcher.stopped:
+		check.Status = "failing"
+		check.Error = "flush loop stopped"
+	default:
+	}
+	return check
+}
+
+// checkStoreWarm makes sure the leaderboards were loaded, since every read
+// is served from them
+func checkStoreWarm() readyCheck {
+	eventRuntimesMu.RLock()
+	events, students := len(eventRuntimes), 0
+	for _, ev := range eventRuntimes {
+		students += ev.leaderboard.Len()
+	}
+	eventRuntimesMu.RUnlock()
+
+	check := readyCheck{Status: "ok", Details: map[string]int{"events": events, "students": students}}
+	if _, ok := getEvent(DEFAULT_EVENT_ID); !ok {
+		check.Status = "failing"
+		check.Error = "leaderboards not loaded"
+	}
+	return check
+}
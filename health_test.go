@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestHealthz(t *testing.T) {
+	server := newTestServer(t, nil)
+	rec := serveJSON(server, "GET", "/healthz", "")
+	var body map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || body["status"] != "ok" {
+		t.Errorf("healthz: %d %v, want 200 ok", rec.Code, body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	// Nothing listens on port 1, so every ping fails
+	down, err := mongo.Connect(context.Background(), options.Client().
+		ApplyURI("mongodb://127.0.0.1:1").
+		SetServerSelectionTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer down.Disconnect(context.Background())
+
+	tests := []struct {
+		name       string
+		journal    string
+		mongoDown  bool
+		draining   bool
+		wantCode   int
+		wantStatus string
+		wantMongo  string
+	}{
+		{"memory backend", "false", false, false, http.StatusOK, "ready", "skipped"},
+		{"mongo down", "false", true, false, http.StatusServiceUnavailable, "not ready", "failing"},
+		{"mongo down with the journal on", "true", true, false, http.StatusOK, "degraded", "degraded"},
+		{"shutting down", "false", false, true, http.StatusServiceUnavailable, "not ready", "skipped"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, map[string]string{"HIT_JOURNAL": tt.journal})
+			if tt.mongoDown {
+				mongoClient = down
+				t.Cleanup(func() { mongoClient = nil })
+			}
+			draining.Store(tt.draining)
+			t.Cleanup(func() { draining.Store(false) })
+
+			rec := serveJSON(server, "GET", "/readyz", "")
+			var body struct {
+				Status string                `json:"status"`
+				Checks map[string]readyCheck `json:"checks"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.wantCode || body.Status != tt.wantStatus {
+				t.Errorf("got %d %q, want %d %q", rec.Code, body.Status, tt.wantCode, tt.wantStatus)
+			}
+			if got := body.Checks["mongo"].Status; got != tt.wantMongo {
+				t.Errorf("mongo check %q, want %q", got, tt.wantMongo)
+			}
+			if _, ok := body.Checks["shutdown"]; ok != tt.draining {
+				t.Errorf("shutdown check present %v, want %v", ok, tt.draining)
+			}
+		})
+	}
+}
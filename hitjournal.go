@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	JOURNAL_RETRY_MS     = 1000 // How often the backlog retries the store and the checkpoint is saved
+	JOURNAL_REPLAY_CHUNK = 500  // Balls per store write when replaying
+)
+
+var (
+	// hitJournal is the write-ahead journal every accepted hit goes to
+	// first. Nil unless HIT_JOURNAL=true.
+	hitJournal *Journal
+
+	// Journaled hits the store has not taken yet, oldest first
+	backlog = &journalBacklog{}
+
+	// Set while the last store write from the journal failed
+	journalStoreDown atomic.Bool
+)
+
+// journalBacklog holds journaled hits waiting for the store. While it is
+// not empty new hits queue behind it, so the store sees them in order.
+type journalBacklog struct {
+	mu      sync.Mutex
+	entries []JournalEntry
+	shown   map[uint64]bool // Entries projected onto the boards
+
+	drainMu sync.Mutex // One drain at a time
+}
+
+// loadJournalConfig opens the journal in JOURNAL_DIR if HIT_JOURNAL=true,
+// and replays whatever the last run wrote but never confirmed in the
+// store. Call it after loadEvents.
+func loadJournalConfig(ctx context.Context) error {
+	cfg := currentConfig().Journal
+	if !cfg.Enabled {
+		logger.Info("hit journal off")
+		return nil
+	}
+
+	dir := cfg.Dir
+	journal, pending, err := OpenJournal(dir)
+	if err != nil {
+		return fmt.Errorf("JOURNAL_DIR: %w", err)
+	}
+	hitJournal = journal
+	logger.Info("hit journal on", "dir", dir)
+
+	if len(pending) > 0 {
+		logger.Info("replaying journaled hits", "hits", len(pending))
+		for _, entry := range pending {
+			backlog.add(nil, entry, false)
+		}
+		if err := drainBacklog(ctx); err != nil {
+			// Show the hits anyway; the store gets them once it is back
+			logger.Warn("journal replay failed, hits stay queued", "error", err.Error())
+			backlog.projectAll()
+		}
+	}
+	go runJournal(ctx)
+	return nil
+}
+
+// recordHit is hitShot's write-through path. The ball is journaled first,
+// so once this returns it survives a crash or a store outage. If the store
+// fails, or earlier hits are still waiting for it, the ball joins the
+// backlog and the boards are updated without the store.
+func recordHit(ctx context.Context, ev *eventRuntime, ball BallEvent) (*Student, error) {
+	seq, err := hitJournal.Append(ball)
+	if err != nil {
+		return nil, err
+	}
+	entry := JournalEntry{Seq: seq, Ball: ball}
+
+	if student, queued := backlog.add(ev, entry, true); queued {
+		return &student, nil
+	}
+
+	if err := ballLog.Append(ctx, ball); err != nil {
+		return queueAfterFailure(ctx, ev, entry, err), nil
+	}
+	student, err := ev.store.RecordShot(ctx, ball)
+	if err != nil {
+		return queueAfterFailure(ctx, ev, entry, err), nil
+	}
+	hitJournal.MarkApplied(seq)
+	ev.apply(*student)
+	return student, nil
+}
+
+func queueAfterFailure(ctx context.Context, ev *eventRuntime, entry JournalEntry, err error) *Student {
+	contextLogger(ctx).Warn("store write failed, hit queued in the journal",
+		"event", entry.Ball.EventID, "roll", entry.Ball.RollNumber, "seq", entry.Seq, "error", err.Error())
+	journalStoreDown.Store(true)
+	student, _ := backlog.add(ev, entry, false)
+	return &student
+}
+
+// add queues an entry in seq order and, if ev is set, shows it on the
+// boards. With onlyIfBusy it does nothing unless the backlog already has
+// entries.
+func (b *journalBacklog) add(ev *eventRuntime, entry JournalEntry, onlyIfBusy bool) (Student, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if onlyIfBusy && len(b.entries) == 0 {
+		return Student{}, false
+	}
+	i := sort.Search(len(b.entries), func(i int) bool { return b.entries[i].Seq > entry.Seq })
+	b.entries = append(b.entries, JournalEntry{})
+	copy(b.entries[i+1:], b.entries[i:])
+	b.entries[i] = entry
+
+	if ev == nil {
+		return Student{}, true
+	}
+	b.show(entry.Seq)
+	return ev.project(entry.Ball), true
+}
+
+func (b *journalBacklog) show(seq uint64) {
+	if b.shown == nil {
+		b.shown = make(map[uint64]bool)
+	}
+	b.shown[seq] = true
+}
+
+// projectAll shows every queued ball on the boards
+func (b *journalBacklog) projectAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, entry := range b.entries {
+		if ev, ok := getEvent(entry.Ball.EventID); ok && !b.shown[entry.Seq] {
+			b.show(entry.Seq)
+			ev.project(entry.Ball)
+		}
+	}
+}
+
+func (b *journalBacklog) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+// drainBacklog writes the backlog to the store in order. Once it is empty
+// the affected boards are refreshed from the store, which now has every
+// ball they were showing unless the batcher holds more.
+func drainBacklog(ctx context.Context) error {
+	backlog.drainMu.Lock()
+	defer backlog.drainMu.Unlock()
+
+	events := make(map[string]bool)
+	for {
+		backlog.mu.Lock()
+		chunk := make([]JournalEntry, min(len(backlog.entries), JOURNAL_REPLAY_CHUNK))
+		copy(chunk, backlog.entries)
+		backlog.mu.Unlock()
+		if len(chunk) == 0 {
+			break
+		}
+
+		start := time.Now()
+		err := replayEntries(ctx, chunk)
+		observeDB("journal_replay", start, err)
+		if err != nil {
+			journalStoreDown.Store(true)
+			return err
+		}
+
+		done := make(map[uint64]bool, len(chunk))
+		seqs := make([]uint64, len(chunk))
+		for i, entry := range chunk {
+			done[entry.Seq] = true
+			seqs[i] = entry.Seq
+			events[entry.Ball.EventID] = true
+		}
+		stored := make(map[string]int)
+		backlog.mu.Lock()
+		kept := backlog.entries[:0]
+		for _, entry := range backlog.entries {
+			switch {
+			case !done[entry.Seq]:
+				kept = append(kept, entry)
+			case backlog.shown[entry.Seq]:
+				delete(backlog.shown, entry.Seq)
+				stored[entry.Ball.EventID]++
+			}
+		}
+		backlog.entries = kept
+		backlog.mu.Unlock()
+		hitJournal.MarkApplied(seqs...)
+		storedHits(stored)
+	}
+	journalStoreDown.Store(false)
+
+	for eventID := range events {
+		if ev, ok := getEvent(eventID); ok {
+			if err := ev.refresh(ctx); err != nil {
+				logger.Error("reloading event failed", "event", eventID, "error", err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// storedHits tells the events how many of their projected hits the store
+// now has, by event ID
+func storedHits(byEvent map[string]int) {
+	for eventID, n := range byEvent {
+		if ev, ok := getEvent(eventID); ok {
+			ev.stored(n)
+		}
+	}
+}
+
+// settleHits gets every hit accepted so far into the store: the batcher's
+// pending balls and the write-through backlog. Call it before an action
+// that older hits must not land after, such as an event reset.
+func settleHits(ctx context.Context) error {
+	if hitBatcher != nil {
+		if err := hitBatcher.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	if hitJournal != nil && backlog.Len() > 0 {
+		return drainBacklog(ctx)
+	}
+	return nil
+}
+
+// replayEntries writes journaled balls to the store, one ball per delta so
+// each is checked on its own. Both writes skip what the store already has,
+// so balls that made it in before a crash are not counted twice.
+func replayEntries(ctx context.Context, entries []JournalEntry) error {
+	balls := make([]BallEvent, len(entries))
+	deltas := make(map[string][]StudentDelta)
+	for i, entry := range entries {
+		balls[i] = entry.Ball
+		var delta StudentDelta
+		delta.add(entry.Ball)
+		deltas[entry.Ball.EventID] = append(deltas[entry.Ball.EventID], delta)
+	}
+
+	if err := ballLog.AppendMany(ctx, balls); err != nil {
+		return err
+	}
+	for eventID, eventDeltas := range deltas {
+		ev, ok := getEvent(eventID)
+		if !ok {
+			return fmt.Errorf("event %s is not loaded", eventID)
+		}
+		if err := ev.store.ApplyBatch(ctx, eventDeltas); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runJournal retries the backlog and saves the checkpoint in the
+// background
+func runJournal(ctx context.Context) {
+	ticker := time.NewTicker(JOURNAL_RETRY_MS * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if backlog.Len() > 0 {
+			drainCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			if err := drainBacklog(drainCtx); err != nil {
+				logger.Warn("store still unavailable, hits stay queued", "backlog", backlog.Len(), "error", err.Error())
+			}
+			cancel()
+		}
+		if err := hitJournal.Checkpoint(); err != nil {
+			logger.Error("journal checkpoint failed", "error", err.Error())
+		}
+	}
+}
+
+// closeJournal gets as much as it can into the store before the process
+// exits. Whatever is left stays in the journal for the next start.
+func closeJournal(ctx context.Context) error {
+	var err error
+	if hitBatcher != nil {
+		err = hitBatcher.Close(ctx)
+	}
+	if backlog.Len() > 0 {
+		if drainErr := drainBacklog(ctx); err == nil {
+			err = drainErr
+		}
+	}
+	if checkpointErr := hitJournal.Checkpoint(); err == nil {
+		err = checkpointErr
+	}
+	if closeErr := hitJournal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// journalStatusView is GET /journal/status
+type journalStatusView struct {
+	Enabled bool   `json:"enabled"`
+	Mode    string `json:"mode,omitempty"` // "write-through" or "write-behind"
+	JournalStatus
+	Backlog   int  `json:"backlog"` // Hits waiting for the store after a failed write
+	StoreDown bool `json:"storeDown"`
+}
+
+// getJournalStatus reports how far the store is behind the journal
+func getJournalStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if hitJournal == nil {
+		json.NewEncoder(w).Encode(journalStatusView{})
+		return
+	}
+
+	view := journalStatusView{
+		Enabled:       true,
+		Mode:          "write-through",
+		JournalStatus: hitJournal.Status(),
+		Backlog:       backlog.Len(),
+		StoreDown:     journalStoreDown.Load(),
+	}
+	if hitBatcher != nil {
+		view.Mode = "write-behind"
+	}
+	json.NewEncoder(w).Encode(view)
+}
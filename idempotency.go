@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	IDEMPOTENCY_KEY_MAX_LENGTH  = 128
+	IDEMPOTENCY_PENDING_SECONDS = 30 // A claim whose request died is freed after this
+	IDEMPOTENCY_SWEEP_SECONDS   = 60
+)
+
+// IdempotencyRecord remembers the response to one Idempotency-Key
+type IdempotencyRecord struct {
+	Key       string    `bson:"_id"`
+	Done      bool      `bson:"done"` // False while the first request is still running
+	Status    int       `bson:"status,omitempty"`
+	Body      []byte    `bson:"body,omitempty"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// IdempotencyStore lets exactly one request claim a key. Stored in Mongo it
+// works across instances.
+type IdempotencyStore interface {
+	// Claim takes the key for this request. If someone else holds it,
+	// claimed is false and their record is returned.
+	Claim(ctx context.Context, key string) (record *IdempotencyRecord, claimed bool, err error)
+	// Complete stores the response so replays get the same answer
+	Complete(ctx context.Context, key string, status int, body []byte) error
+	// Release gives up a claim so the client can retry for real
+	Release(ctx context.Context, key string) error
+}
+
+var (
+	idempotency IdempotencyStore
+	// How long a completed key is remembered
+	idempotencyTTL time.Duration
+)
+
+// newIdempotencyStore opens the remembered /hit responses. Completed keys
+// are kept for IDEMPOTENCY_TTL_SECONDS (default one hour); Mongo drops
+// them with a TTL index.
+func newIdempotencyStore() IdempotencyStore {
+	idempotencyTTL = time.Duration(currentConfig().Server.IdempotencyTTLSeconds) * time.Second
+
+	keys := openCollection("idempotency_keys", mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if keys != nil {
+		return &mongoIdempotencyStore{collection: keys}
+	}
+	return &memoryIdempotencyStore{records: make(map[string]IdempotencyRecord)}
+}
+
+// idempotencyKey reads the Idempotency-Key header, or the idempotencyKey
+// body field for clients that cannot set headers. The key is scoped to the
+// event and player so two players can never see each other's responses.
+func idempotencyKey(r *http.Request, bodyKey, eventID, rollNumber string) (string, error) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		key = bodyKey
+	}
+	if key == "" {
+		return "", nil
+	}
+	if len(key) > IDEMPOTENCY_KEY_MAX_LENGTH {
+		return "", fmt.Errorf("Idempotency-Key must be at most %d characters", IDEMPOTENCY_KEY_MAX_LENGTH)
+	}
+	return eventID + ":" + rollNumber + ":" + key, nil
+}
+
+// sweepIdempotencyKeys drops expired keys from store if it is the
+// in-memory one; Mongo expires them with a TTL index
+func sweepIdempotencyKeys(ctx context.Context, store IdempotencyStore) {
+	local, ok := store.(*memoryIdempotencyStore)
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(IDEMPOTENCY_SWEEP_SECONDS * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			local.sweep(time.Now())
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// MongoDB idempotency keys
+// ---------------------------------------------------------------------------
+
+type mongoIdempotencyStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoIdempotencyStore) Claim(ctx context.Context, key string) (*IdempotencyRecord, bool, error) {
+	now := time.Now()
+	pending := IdempotencyRecord{Key: key, ExpiresAt: now.Add(IDEMPOTENCY_PENDING_SECONDS * time.Second)}
+
+	_, err := s.collection.InsertOne(ctx, pending)
+	if err == nil {
+		return &pending, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, false, err
+	}
+
+	// Someone has the key. Take it over only if their claim or result has
+	// expired but the TTL monitor has not removed it yet.
+	filter := bson.M{"_id": key, "expiresAt": bson.M{"$lte": now}}
+	result, err := s.collection.ReplaceOne(ctx, filter, pending)
+	if err != nil {
+		return nil, false, err
+	}
+	if result.ModifiedCount == 1 {
+		return &pending, true, nil
+	}
+
+	var existing IdempotencyRecord
+	if err := s.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&existing); err != nil {
+		return nil, false, err
+	}
+	return &existing, false, nil
+}
+
+func (s *mongoIdempotencyStore) Complete(ctx context.Context, key string, status int, body []byte) error {
+	update := bson.M{"$set": bson.M{
+		"done":      true,
+		"status":    status,
+		"body":      body,
+		"expiresAt": time.Now().Add(idempotencyTTL),
+	}}
+	_, err := s.collection.UpdateOne(ctx, bson.M{"_id": key}, update)
+	return err
+}
+
+func (s *mongoIdempotencyStore) Release(ctx context.Context, key string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"_id": key, "done": false})
+	return err
+}
+
+// ---------------------------------------------------------------------------
+// In-memory idempotency keys
+// ---------------------------------------------------------------------------
+
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+}
+
+func (s *memoryIdempotencyStore) Claim(ctx context.Context, key string) (*IdempotencyRecord, bool, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, exists := s.records[key]; exists && existing.ExpiresAt.After(now) {
+		return &existing, false, nil
+	}
+	pending := IdempotencyRecord{Key: key, ExpiresAt: now.Add(IDEMPOTENCY_PENDING_SECONDS * time.Second)}
+	s.records[key] = pending
+	return &pending, true, nil
+}
+
+func (s *memoryIdempotencyStore) Complete(ctx context.Context, key string, status int, body []byte) error {
+	s.mu.Lock()
+	s.records[key] = IdempotencyRecord{
+		Key:       key,
+		Done:      true,
+		Status:    status,
+		Body:      body,
+		ExpiresAt: time.Now().Add(idempotencyTTL),
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	if record, exists := s.records[key]; exists && !record.Done {
+		delete(s.records, key)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryIdempotencyStore) sweep(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, record := range s.records {
+		if !record.ExpiresAt.After(now) {
+			delete(s.records, key)
+		}
+	}
+}
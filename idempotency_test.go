@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// flakyIdempotency fails Complete when a test asks it to, or when it gets
+// a context that is already done, as the Mongo store would
+type flakyIdempotency struct {
+	IdempotencyStore
+	failComplete bool
+}
+
+func (s *flakyIdempotency) Complete(ctx context.Context, key string, status int, body []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.failComplete {
+		return errStoreDown
+	}
+	return s.IdempotencyStore.Complete(ctx, key, status, body)
+}
+
+// slowStore records shots after the request's deadline has passed, the
+// way a write that only just made it looks
+type slowStore struct {
+	ScoreStore
+}
+
+func (s *slowStore) RecordShot(ctx context.Context, ball BallEvent) (*Student, error) {
+	<-ctx.Done()
+	return s.ScoreStore.RecordShot(context.Background(), ball)
+}
+
+func hitWithKey(handler http.Handler, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/hit", strings.NewReader(`{"rollNumber":"2021000001","name":"Asha"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func ballsFaced(t *testing.T) int {
+	t.Helper()
+	ev, _ := getEvent(DEFAULT_EVENT_ID)
+	student, err := ev.store.GetStudent(context.Background(), "2021000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return student.BallsFaced
+}
+
+func TestIdempotencyCompleteAfterSlowWrite(t *testing.T) {
+	server := newTestServer(t, map[string]string{"DB_TIMEOUT_MS": "20"})
+	idempotency = &flakyIdempotency{IdempotencyStore: idempotency}
+	ev, _ := getEvent(DEFAULT_EVENT_ID)
+	ev.store = &slowStore{ScoreStore: ev.store}
+
+	first := hitWithKey(server, "slow-1")
+	if first.Code != http.StatusOK {
+		t.Fatalf("hit: %d %s", first.Code, first.Body.String())
+	}
+	retry := hitWithKey(server, "slow-1")
+	if retry.Code != http.StatusOK || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("retry was not replayed: %d %s", retry.Code, retry.Body.String())
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("replayed %s, want %s", retry.Body.String(), first.Body.String())
+	}
+	if balls := ballsFaced(t); balls != 1 {
+		t.Errorf("balls faced = %d, want 1", balls)
+	}
+}
+
+func TestIdempotencyCompleteFails(t *testing.T) {
+	server := newTestServer(t, nil)
+	idempotency = &flakyIdempotency{IdempotencyStore: idempotency, failComplete: true}
+
+	if rec := hitWithKey(server, "fail-1"); rec.Code != http.StatusOK {
+		t.Fatalf("hit: %d %s", rec.Code, rec.Body.String())
+	}
+	// The response was never stored, but the claim is kept so a retry
+	// can't play the ball again
+	if rec := hitWithKey(server, "fail-1"); rec.Code != http.StatusConflict {
+		t.Errorf("retry: %d %s, want 409", rec.Code, rec.Body.String())
+	}
+	if balls := ballsFaced(t); balls != 1 {
+		t.Errorf("balls faced = %d, want 1", balls)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JOURNAL_FILE          = "hits.journal"
+	JOURNAL_CHECKPOINT    = "checkpoint"
+	JOURNAL_COMPACT_BYTES = 1 << 20 // Truncate once everything is applied and the file is bigger than this
+	JOURNAL_MAX_LINE      = 64 << 10
+)
+
+var ErrJournalClosed = errors.New("journal is closed")
+
+// JournalStatus is how far the store is behind the journal
+type JournalStatus struct {
+	LastSeq       uint64     `json:"lastSeq"`       // Newest entry written
+	AppliedSeq    uint64     `json:"appliedSeq"`    // Every entry up to here is in the store
+	CheckpointSeq uint64     `json:"checkpointSeq"` // AppliedSeq as last saved to disk
+	Lag           uint64     `json:"lag"`           // Entries written but not yet in the store
+	OldestPending *time.Time `json:"oldestPending,omitempty"`
+	Bytes         int64      `json:"bytes"`
+}
+
+// JournalEntry is one accepted ball in the local journal. Seq increases by
+// one per entry and never repeats, even across compactions.
+type JournalEntry struct {
+	Seq  uint64    `json:"seq"`
+	Ball BallEvent `json:"ball"`
+}
+
+// Journal is an append-only file of accepted balls on the local disk. A
+// ball is acknowledged once its line is fsynced; the checkpoint file
+// records how far the entries have made it into the store. Concurrent
+// appends are written and fsynced together (group commit), so a burst of
+// hits costs one fsync rather than one each.
+type Journal struct {
+	dir string
+
+	mu           sync.Mutex // Guards everything below
+	file         *os.File
+	size         int64
+	lastSeq      uint64               // Highest seq written
+	applied      uint64               // Every seq up to here is in the store
+	checkpointed uint64               // applied as last written to the checkpoint file
+	waiting      map[uint64]time.Time // Written but not yet in the store, with when
+
+	writes  chan *journalWrite
+	closing chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+type journalWrite struct {
+	ball BallEvent
+	seq  uint64
+	err  error
+	done chan struct{}
+}
+
+// OpenJournal opens or creates the journal in dir and returns the entries
+// that were written but never checkpointed, oldest first
+func OpenJournal(dir string) (*Journal, []JournalEntry, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, nil, err
+	}
+	j := &Journal{
+		dir:     dir,
+		writes:  make(chan *journalWrite),
+		closing: make(chan struct{}),
+		stopped: make(chan struct{}),
+		waiting: make(map[uint64]time.Time),
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, JOURNAL_CHECKPOINT))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	if len(raw) > 0 {
+		if j.applied, err = strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64); err != nil {
+			return nil, nil, fmt.Errorf("journal checkpoint: %w", err)
+		}
+	}
+	j.lastSeq = j.applied
+	j.checkpointed = j.applied
+
+	j.file, err = os.OpenFile(filepath.Join(dir, JOURNAL_FILE), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	pending, err := j.recover()
+	if err != nil {
+		j.file.Close()
+		return nil, nil, err
+	}
+
+	go j.run()
+	return j, pending, nil
+}
+
+// recover reads the whole file. Only the last line can be torn by a crash
+// mid-write, so a last line without its newline is cut off, whatever it
+// holds; it was never acknowledged. Any other line that can't be read
+// stops startup rather than being dropped along with everything after it.
+func (j *Journal) recover() ([]JournalEntry, error) {
+	var pending []JournalEntry
+	var good int64
+
+	reader := bufio.NewReaderSize(j.file, JOURNAL_MAX_LINE+1)
+	for number := 1; ; number++ {
+		line, err := reader.ReadSlice('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err == bufio.ErrBufferFull {
+			// Too long to be one of ours. Find out whether it is the torn tail.
+			err = skipLine(reader)
+			if err == nil {
+				return nil, fmt.Errorf("journal %s: line %d at byte %d is longer than %d bytes; move the file aside to start without it",
+					j.file.Name(), number, good, JOURNAL_MAX_LINE)
+			}
+		}
+		if err == io.EOF {
+			break // Torn tail
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var entry JournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("journal %s: line %d at byte %d: %w; move the file aside to start without it",
+				j.file.Name(), number, good, err)
+		}
+		good += int64(len(line))
+		if entry.Seq > j.lastSeq {
+			j.lastSeq = entry.Seq
+		}
+		if entry.Seq > j.applied {
+			pending = append(pending, entry)
+			j.waiting[entry.Seq] = entry.Ball.Timestamp
+		}
+	}
+
+	info, err := j.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > good {
+		logger.Warn("journal: dropping torn write", "file", j.file.Name(), "bytes", info.Size()-good)
+		if err := j.file.Truncate(good); err != nil {
+			return nil, err
+		}
+	}
+	j.size = good
+	return pending, nil
+}
+
+// skipLine reads up to and including the next newline. It returns io.EOF
+// if the file ends first.
+func skipLine(reader *bufio.Reader) error {
+	for {
+		_, err := reader.ReadSlice('\n')
+		if err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+}
+
+// Append writes the ball and returns once it is on disk. The entry counts
+// as lag until MarkApplied is called with its seq.
+func (j *Journal) Append(ball BallEvent) (uint64, error) {
+	w := &journalWrite{ball: ball, done: make(chan struct{})}
+	select {
+	case j.writes <- w:
+	case <-j.closing:
+		return 0, ErrJournalClosed
+	}
+	<-w.done
+	return w.seq, w.err
+}
+
+// run is the single writer. It takes whatever appends are waiting and
+// writes them as one batch.
+func (j *Journal) run() {
+	defer close(j.stopped)
+
+	for {
+		var first *journalWrite
+		select {
+		case first = <-j.writes:
+		case <-j.closing:
+			return
+		}
+
+		batch := []*journalWrite{first}
+		for more := true; more; {
+			select {
+			case w := <-j.writes:
+				batch = append(batch, w)
+			default:
+				more = false
+			}
+		}
+		j.writeBatch(batch)
+	}
+}
+
+func (j *Journal) writeBatch(batch []*journalWrite) {
+	j.mu.Lock()
+	var buf bytes.Buffer
+	seq := j.lastSeq
+	var err error
+	now := time.Now()
+	for _, w := range batch {
+		seq++
+		w.seq = seq
+		line, marshalErr := json.Marshal(JournalEntry{Seq: seq, Ball: w.ball})
+		if marshalErr != nil {
+			err = marshalErr
+			break
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err == nil {
+		_, err = j.file.Write(buf.Bytes())
+	}
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err == nil {
+		for _, w := range batch {
+			j.waiting[w.seq] = now
+		}
+		j.lastSeq = seq
+		j.size += int64(buf.Len())
+	} else {
+		// Cut off anything half written so the file stays readable
+		j.file.Truncate(j.size)
+	}
+	j.mu.Unlock()
+
+	for _, w := range batch {
+		w.err = err
+		close(w.done)
+	}
+}
+
+// MarkApplied records that the entries are in the store. Entries can be
+// applied out of order; the applied mark only moves past a seq once
+// everything before it is in too.
+func (j *Journal) MarkApplied(seqs ...uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, seq := range seqs {
+		delete(j.waiting, seq)
+	}
+	j.applied = j.lastSeq
+	for seq := range j.waiting {
+		if seq <= j.applied {
+			j.applied = seq - 1
+		}
+	}
+}
+
+// Checkpoint saves the applied mark so a restart only replays what came
+// after it. Once everything is applied a large file is truncated.
+func (j *Journal) Checkpoint() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.applied <= j.checkpointed {
+		return nil
+	}
+	if err := writeFileSync(filepath.Join(j.dir, JOURNAL_CHECKPOINT), []byte(strconv.FormatUint(j.applied, 10)+"\n")); err != nil {
+		return err
+	}
+	j.checkpointed = j.applied
+
+	if j.applied == j.lastSeq && j.size > JOURNAL_COMPACT_BYTES {
+		if err := j.file.Truncate(0); err != nil {
+			return err
+		}
+		j.size = 0
+	}
+	return nil
+}
+
+func (j *Journal) Status() JournalStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := JournalStatus{
+		LastSeq:       j.lastSeq,
+		AppliedSeq:    j.applied,
+		CheckpointSeq: j.checkpointed,
+		Lag:           uint64(len(j.waiting)),
+		Bytes:         j.size,
+	}
+	if oldest, ok := j.waiting[j.applied+1]; ok {
+		status.OldestPending = &oldest
+	}
+	return status
+}
+
+// Close stops accepting appends. A batch being written is finished first;
+// appends still waiting get ErrJournalClosed.
+func (j *Journal) Close() error {
+	j.once.Do(func() { close(j.closing) })
+	<-j.stopped
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// writeFileSync replaces path atomically: write a temp file, fsync, rename
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func journalLine(t *testing.T, seq uint64) string {
+	t.Helper()
+	line, err := json.Marshal(JournalEntry{Seq: seq, Ball: BallEvent{ID: fmt.Sprint("ball-", seq), RollNumber: "2021000001"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(line) + "\n"
+}
+
+func TestJournalRecovery(t *testing.T) {
+	oversize := `{"seq":9,"ball":{"id":"` + strings.Repeat("x", JOURNAL_MAX_LINE) + `"}}`
+
+	tests := []struct {
+		name     string
+		tail     string // Written after two good entries and before a third
+		last     string // Written at the very end
+		wantSeqs []uint64
+		wantErr  bool
+	}{
+		{name: "clean", wantSeqs: []uint64{1, 2, 3}},
+		{name: "torn tail", last: `{"seq":4,"ball":{"id":"ba`, wantSeqs: []uint64{1, 2, 3}},
+		{name: "whole tail without newline", last: strings.TrimSuffix(journalLine(t, 4), "\n"), wantSeqs: []uint64{1, 2, 3}},
+		{name: "oversize torn tail", last: oversize, wantSeqs: []uint64{1, 2, 3}},
+		{name: "corrupt middle line", tail: "{\"seq\":\n", wantErr: true},
+		{name: "oversize middle line", tail: oversize + "\n", wantErr: true},
+		{name: "corrupt last line with newline", last: "not json\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, JOURNAL_FILE)
+			content := journalLine(t, 1) + journalLine(t, 2) + tt.tail + journalLine(t, 3) + tt.last
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			journal, pending, err := OpenJournal(dir)
+			if tt.wantErr {
+				if err == nil {
+					journal.Close()
+					t.Fatal("opened a journal with a bad line in it")
+				}
+				// Nothing may be thrown away, so the file can be fixed by hand
+				if after, _ := os.ReadFile(path); string(after) != content {
+					t.Error("journal was changed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var seqs []uint64
+			for _, entry := range pending {
+				seqs = append(seqs, entry.Seq)
+			}
+			if len(seqs) != len(tt.wantSeqs) {
+				t.Fatalf("recovered %v, want %v", seqs, tt.wantSeqs)
+			}
+			for i := range seqs {
+				if seqs[i] != tt.wantSeqs[i] {
+					t.Fatalf("recovered %v, want %v", seqs, tt.wantSeqs)
+				}
+			}
+
+			// The torn tail is gone, so new entries start on a fresh line
+			seq, err := journal.Append(BallEvent{ID: "next"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seq != 4 {
+				t.Errorf("next seq = %d, want 4", seq)
+			}
+			journal.Close()
+			reopened, pending, err := OpenJournal(dir)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			reopened.Close()
+			if len(pending) != 4 {
+				t.Errorf("reopen recovered %d entries, want 4", len(pending))
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	SKIPLIST_MAX_LEVEL = 32
+	SKIPLIST_P         = 0.25 // Chance a node is promoted one more level
+)
+
+// Leaderboard is an in-process ranked view of every student, ordered by
+// score (highest first) with roll number as tie-break. It is an indexable
+// skip list: each link remembers how many nodes it jumps over, so top-N,
+// rank-of-student and page lookups are all O(log n).
+type Leaderboard struct {
+	mu     sync.RWMutex
+	head   *skipNode
+	level  int
+	length int
+	byRoll map[string]*skipNode
+	rng    *rand.Rand
+}
+
+type skipNode struct {
+	student Student
+	next    []skipLink
+}
+
+type skipLink struct {
+	node *skipNode
+	span int // Nodes passed by following this link
+}
+
+func NewLeaderboard() *Leaderboard {
+	return &Leaderboard{
+		head:   &skipNode{next: make([]skipLink, SKIPLIST_MAX_LEVEL)},
+		level:  1,
+		byRoll: make(map[string]*skipNode),
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// ranksBefore reports whether a sorts ahead of b on the leaderboard
+func ranksBefore(a, b *Student) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.RollNumber < b.RollNumber
+}
+
+func (lb *Leaderboard) randomLevel() int {
+	level := 1
+	for level < SKIPLIST_MAX_LEVEL && lb.rng.Float64() < SKIPLIST_P {
+		level++
+	}
+	return level
+}
+
+// Load replaces the whole board, e.g. at startup or on a resync
+func (lb *Leaderboard) Load(students []Student) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.head = &skipNode{next: make([]skipLink, SKIPLIST_MAX_LEVEL)}
+	lb.level = 1
+	lb.length = 0
+	lb.byRoll = make(map[string]*skipNode, len(students))
+	for _, student := range students {
+		lb.upsert(student)
+	}
+}
+
+// Upsert inserts the student or moves them to their new position
+func (lb *Leaderboard) Upsert(student Student) {
+	lb.mu.Lock()
+	lb.upsert(student)
+	lb.mu.Unlock()
+}
+
+// Remove drops a student from the board
+func (lb *Leaderboard) Remove(rollNumber string) {
+	lb.mu.Lock()
+	if node, exists := lb.byRoll[rollNumber]; exists {
+		lb.delete(&node.student)
+		delete(lb.byRoll, rollNumber)
+	}
+	lb.mu.Unlock()
+}
+
+// Len is the number of students on the board
+func (lb *Leaderboard) Len() int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+	return lb.length
+}
+
+// Get returns a student's current entry
+func (lb *Leaderboard) Get(rollNumber string) (Student, bool) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	node, exists := lb.byRoll[rollNumber]
+	if !exists {
+		return Student{}, false
+	}
+	return node.student, true
+}
+
+// Rank returns the student's 1-based rank. Tied scores share a rank, so
+// the rank is one more than the number of students with a higher score.
+func (lb *Leaderboard) Rank(rollNumber string) (int, bool) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	node, exists := lb.byRoll[rollNumber]
+	if !exists {
+		return 0, false
+	}
+	return lb.countAbove(node.student.Score) + 1, true
+}
+
+// Range returns up to limit ranked rows starting at the 0-based offset
+func (lb *Leaderboard) Range(offset, limit int) []RankedStudent {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 || offset >= lb.length {
+		return []RankedStudent{}
+	}
+	if offset+limit > lb.length {
+		limit = lb.length - offset
+	}
+
+	rows := make([]RankedStudent, 0, limit)
+	node := lb.nodeAt(offset)
+	rank := lb.countAbove(node.student.Score) + 1
+	for position := offset; node != nil && len(rows) < limit; position++ {
+		if len(rows) > 0 && node.student.Score != rows[len(rows)-1].Score {
+			rank = position + 1
+		}
+		rows = append(rows, RankedStudent{Student: node.student, Rank: rank})
+		node = node.next[0].node
+	}
+	return rows
+}
+
+// Ranges returns the ranked rows whose score falls in any of the spans,
+// in board order and without duplicates
+func (lb *Leaderboard) Ranges(spans []scoreSpan) []RankedStudent {
+	lb.mu.RLock()
+	type window struct{ start, end int }
+	windows := make([]window, 0, len(spans))
+	for _, span := range spans {
+		// Rows with a score of at most span.high start after every row above
+		// it, and rows of at least span.low end where lower scores begin
+		w := window{start: lb.countAbove(span.high), end: lb.length}
+		if span.low > math.MinInt {
+			w.end = lb.countAbove(span.low - 1)
+		}
+		if w.start < w.end {
+			windows = append(windows, w)
+		}
+	}
+	lb.mu.RUnlock()
+
+	sort.Slice(windows, func(i, j int) bool { return windows[i].start < windows[j].start })
+	var rows []RankedStudent
+	covered := 0
+	for _, w := range windows {
+		if w.start < covered {
+			w.start = covered
+		}
+		if w.start >= w.end {
+			continue
+		}
+		rows = append(rows, lb.Range(w.start, w.end-w.start)...)
+		covered = w.end
+	}
+	return rows
+}
+
+// Top returns the n highest ranked rows
+func (lb *Leaderboard) Top(n int) []RankedStudent {
+	return lb.Range(0, n)
+}
+
+// All returns every student in rank order
+func (lb *Leaderboard) All() []Student {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	students := make([]Student, 0, lb.length)
+	for node := lb.head.next[0].node; node != nil; node = node.next[0].node {
+		students = append(students, node.student)
+	}
+	return students
+}
+
+// upsert and the helpers below expect lb.mu to be held
+
+func (lb *Leaderboard) upsert(student Student) {
+	if existing, exists := lb.byRoll[student.RollNumber]; exists {
+		lb.delete(&existing.student)
+	}
+	lb.byRoll[student.RollNumber] = lb.insert(student)
+}
+
+func (lb *Leaderboard) insert(student Student) *skipNode {
+	var update [SKIPLIST_MAX_LEVEL]*skipNode
+	var rank [SKIPLIST_MAX_LEVEL]int
+
+	x := lb.head
+	for i := lb.level - 1; i >= 0; i-- {
+		if i < lb.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.next[i].node != nil && ranksBefore(&x.next[i].node.student, &student) {
+			rank[i] += x.next[i].span
+			x = x.next[i].node
+		}
+		update[i] = x
+	}
+
+	level := lb.randomLevel()
+	if level > lb.level {
+		for i := lb.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = lb.head
+			update[i].next[i].span = lb.length
+		}
+		lb.level = level
+	}
+
+	node := &skipNode{student: student, next: make([]skipLink, level)}
+	for i := 0; i < level; i++ {
+		node.next[i].node = update[i].next[i].node
+		update[i].next[i].node = node
+		node.next[i].span = update[i].next[i].span - (rank[0] - rank[i])
+		update[i].next[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < lb.level; i++ {
+		update[i].next[i].span++
+	}
+
+	lb.length++
+	return node
+}
+
+func (lb *Leaderboard) delete(student *Student) {
+	var update [SKIPLIST_MAX_LEVEL]*skipNode
+
+	x := lb.head
+	for i := lb.level - 1; i >= 0; i-- {
+		for x.next[i].node != nil && ranksBefore(&x.next[i].node.student, student) {
+			x = x.next[i].node
+		}
+		update[i] = x
+	}
+
+	target := x.next[0].node
+	if target == nil || target.student.RollNumber != student.RollNumber {
+		return
+	}
+
+	for i := 0; i < lb.level; i++ {
+		if update[i].next[i].node == target {
+			update[i].next[i].span += target.next[i].span - 1
+			update[i].next[i].node = target.next[i].node
+		} else {
+			update[i].next[i].span--
+		}
+	}
+	for lb.level > 1 && lb.head.next[lb.level-1].node == nil {
+		lb.level--
+	}
+	lb.length--
+}
+
+// countAbove counts students with a strictly higher score
+func (lb *Leaderboard) countAbove(score int) int {
+	count := 0
+	x := lb.head
+	for i := lb.level - 1; i >= 0; i-- {
+		for x.next[i].node != nil && x.next[i].node.student.Score > score {
+			count += x.next[i].span
+			x = x.next[i].node
+		}
+	}
+	return count
+}
+
+// nodeAt returns the node at a 0-based position
+func (lb *Leaderboard) nodeAt(index int) *skipNode {
+	target := index + 1
+	traversed := 0
+	x := lb.head
+	for i := lb.level - 1; i >= 0; i-- {
+		for x.next[i].node != nil && traversed+x.next[i].span <= target {
+			traversed += x.next[i].span
+			x = x.next[i].node
+		}
+		if traversed == target {
+			return x
+		}
+	}
+	return nil
+}
+
+// NextScoreAbove returns the lowest score strictly higher than score, i.e.
+// what a player on score has to beat to move up a rank
+func (lb *Leaderboard) NextScoreAbove(score int) (int, bool) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	above := lb.countAbove(score)
+	if above == 0 {
+		return 0, false
+	}
+	return lb.nodeAt(above - 1).student.Score, true
+}
+
+// Position returns the student's 0-based index in board order
+func (lb *Leaderboard) Position(rollNumber string) (int, bool) {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	node, exists := lb.byRoll[rollNumber]
+	if !exists {
+		return 0, false
+	}
+	return lb.countThrough(&node.student) - 1, true
+}
+
+// PositionAfter returns the index of the first row that sorts after the
+// given score and roll number. Used to resume paging from a cursor even if
+// that student has since moved.
+func (lb *Leaderboard) PositionAfter(score int, rollNumber string) int {
+	lb.mu.RLock()
+	defer lb.mu.RUnlock()
+
+	return lb.countThrough(&Student{Score: score, RollNumber: rollNumber})
+}
+
+// countThrough counts rows that sort before or equal to key
+func (lb *Leaderboard) countThrough(key *Student) int {
+	count := 0
+	x := lb.head
+	for i := lb.level - 1; i >= 0; i-- {
+		for x.next[i].node != nil && !ranksBefore(key, &x.next[i].node.student) {
+			count += x.next[i].span
+			x = x.next[i].node
+		}
+	}
+	return count
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	LIVE_MIN_INTERVAL_MS   = 250 // Minimum gap between two leaderboard pushes
+	LIVE_CLIENT_BUFFER     = 16  // Pending messages per client before it is dropped
+	LIVE_HEARTBEAT_SECONDS = 15  // Keeps proxies from closing idle streams
+)
+
+// RankedStudent is a leaderboard row with its position
+type RankedStudent struct {
+	Student
+	Rank int `json:"rank"`
+}
+
+// RankChange describes one row that moved or scored since the last push.
+// PreviousRank is 0 for a student who just joined the board.
+type RankChange struct {
+	RankedStudent
+	PreviousRank int `json:"previousRank"`
+}
+
+// scoreboardDiff is the payload of every "diff" event on the stream.
+// Removed lists roll numbers a moderator took off the board.
+type scoreboardDiff struct {
+	Changes []RankChange `json:"changes"`
+	Removed []string     `json:"removed,omitempty"`
+	Total   int          `json:"total"`
+}
+
+// scoreboardHub turns score changes into leaderboard diffs and fans them out
+// to every SSE subscriber. Writers only poke a size-1 channel, so hitShot
+// never waits on the hub, and bursts of hits collapse into a single push.
+// Writers also say which scores moved, so a push only re-reads the rows
+// whose rank could have changed rather than the whole board.
+type scoreboardHub struct {
+	board  *Leaderboard
+	notify chan struct{}
+
+	mu       sync.Mutex
+	clients  map[chan []byte]struct{}
+	previous map[string]RankedStudent
+	full     bool            // Everything may have changed, e.g. after a reload
+	spans    []scoreSpan     // Scores whose rows may have moved
+	gone     map[string]bool // Roll numbers that may have left the board
+}
+
+// scoreSpan is a range of scores, inclusive at both ends. A student moving
+// from one score to another changes the rank of every row in between.
+type scoreSpan struct {
+	low, high int
+}
+
+func newScoreboardHub(board *Leaderboard) *scoreboardHub {
+	return &scoreboardHub{
+		board:    board,
+		notify:   make(chan struct{}, 1),
+		clients:  make(map[chan []byte]struct{}),
+		previous: make(map[string]RankedStudent),
+		gone:     make(map[string]bool),
+	}
+}
+
+// Notify tells the hub the whole leaderboard may have changed. It never
+// blocks.
+func (h *scoreboardHub) Notify() {
+	h.mu.Lock()
+	h.full = true
+	h.mu.Unlock()
+	h.wake()
+}
+
+// Changed tells the hub one student went from before to after; nil means
+// not on the board. It never blocks.
+func (h *scoreboardHub) Changed(before, after *Student) {
+	var span scoreSpan
+	switch {
+	case before == nil && after == nil:
+		return
+	case before == nil:
+		// Joining pushes every row below down a rank
+		span = scoreSpan{low: math.MinInt, high: after.Score}
+	case after == nil:
+		// Leaving pulls every row below up a rank
+		span = scoreSpan{low: math.MinInt, high: before.Score}
+	default:
+		span = scoreSpan{low: min(before.Score, after.Score), high: max(before.Score, after.Score)}
+	}
+
+	h.mu.Lock()
+	h.spans = append(h.spans, span)
+	if after == nil {
+		h.gone[before.RollNumber] = true
+	}
+	h.mu.Unlock()
+	h.wake()
+}
+
+func (h *scoreboardHub) wake() {
+	select {
+	case h.notify <- struct{}{}:
+	default:
+		// A refresh is already pending and will include this change
+	}
+}
+
+// Run recomputes and broadcasts diffs until ctx is cancelled
+func (h *scoreboardHub) Run(ctx context.Context) {
+	h.Notify() // Prime the first snapshot
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.notify:
+		}
+
+		if err := h.refresh(); err != nil {
+			logger.Error("live scoreboard refresh failed", "error", err.Error())
+		}
+
+		// Coalesce hits that land while we wait into the next push
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(LIVE_MIN_INTERVAL_MS * time.Millisecond):
+		}
+	}
+}
+
+// refresh reads the rows that may have changed since the last push, diffs
+// them against what subscribers were last sent and broadcasts the changes
+func (h *scoreboardHub) refresh() error {
+	h.mu.Lock()
+	full, spans, gone := h.full, h.spans, h.gone
+	h.full, h.spans, h.gone = false, nil, make(map[string]bool)
+	h.mu.Unlock()
+
+	total := h.board.Len()
+	var rows []RankedStudent
+	if full {
+		rows = h.board.Range(0, total)
+	} else {
+		rows = h.board.Ranges(spans)
+	}
+
+	h.mu.Lock()
+	changes := []RankChange{}
+	var removed []string
+	if full {
+		current := make(map[string]RankedStudent, len(rows))
+		for _, row := range rows {
+			current[row.RollNumber] = row
+		}
+		for rollNumber := range h.previous {
+			if _, exists := current[rollNumber]; !exists {
+				removed = append(removed, rollNumber)
+			}
+		}
+		h.previous = make(map[string]RankedStudent, len(rows))
+	} else {
+		for rollNumber := range gone {
+			if _, sent := h.previous[rollNumber]; !sent {
+				continue
+			}
+			if _, exists := h.board.Get(rollNumber); !exists {
+				removed = append(removed, rollNumber)
+				delete(h.previous, rollNumber)
+			}
+		}
+	}
+	for _, row := range rows {
+		before, seen := h.previous[row.RollNumber]
+		h.previous[row.RollNumber] = row
+		if seen && before.Rank == row.Rank && before.Score == row.Score && before.Name == row.Name {
+			continue
+		}
+		change := RankChange{RankedStudent: row}
+		if seen {
+			change.PreviousRank = before.Rank
+		}
+		changes = append(changes, change)
+	}
+	h.mu.Unlock()
+
+	if len(changes) == 0 && len(removed) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(scoreboardDiff{Changes: changes, Removed: removed, Total: total})
+	if err != nil {
+		return err
+	}
+	h.broadcast(sseMessage("diff", payload))
+	return nil
+}
+
+// broadcast hands msg to every client without blocking. A client whose
+// buffer is full is too slow to keep up and gets disconnected; the browser's
+// EventSource reconnects and starts again from a fresh snapshot.
+func (h *scoreboardHub) broadcast(msg []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients {
+		select {
+		case client <- msg:
+		default:
+			delete(h.clients, client)
+			close(client)
+		}
+	}
+}
+
+// subscribe registers a client and returns its channel plus a snapshot of
+// the board, taken under the same lock so no diff is missed in between. The
+// snapshot may be newer than the last diff; the next diff carries every row
+// that changed since, so the client catches up.
+func (h *scoreboardHub) subscribe() (chan []byte, []RankedStudent) {
+	client := make(chan []byte, LIVE_CLIENT_BUFFER)
+
+	h.mu.Lock()
+	h.clients[client] = struct{}{}
+	snapshot := h.board.Range(0, h.board.Len())
+	h.mu.Unlock()
+
+	return client, snapshot
+}
+
+func (h *scoreboardHub) clientCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+func (h *scoreboardHub) unsubscribe(client chan []byte) {
+	h.mu.Lock()
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		close(client)
+	}
+	h.mu.Unlock()
+}
+
+// sseMessage formats a single Server-Sent Events message
+func sseMessage(event string, data []byte) []byte {
+	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, data))
+}
+
+// streamScoreboard serves GET /scoreboard/stream. The first message is a
+// full "snapshot"; every later "diff" only carries rows that changed.
+func streamScoreboard(w http.ResponseWriter, r *http.Request) {
+	ev, ok := eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable proxy buffering
+
+	client, snapshot := ev.hub.subscribe()
+	defer ev.hub.unsubscribe(client)
+
+	if snapshot == nil {
+		snapshot = []RankedStudent{}
+	}
+	payload, err := json.Marshal(snapshot)
+	if err != nil {
+		noteError(r.Context(), "", err)
+		return
+	}
+	w.Write(sseMessage("snapshot", payload))
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(LIVE_HEARTBEAT_SECONDS * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-serverClosing:
+			return // Shutting down; the client reconnects to another instance
+		case msg, open := <-client:
+			if !open {
+				return // Dropped for being too slow
+			}
+			if _, err := w.Write(msg); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A client that starts from a snapshot and applies every diff the way
+// script.js does must end up with the same rows as the board
+func TestScoreboardDiffsFollowTheBoard(t *testing.T) {
+	board := NewLeaderboard()
+	hub := newScoreboardHub(board)
+	ev := &eventRuntime{leaderboard: board, teams: NewTeamBoard(), hub: hub}
+	for i := 0; i < 50; i++ {
+		ev.apply(Student{RollNumber: fmt.Sprintf("%010d", i), Score: i % 7})
+	}
+	if err := hub.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	client, snapshot := hub.subscribe()
+	defer hub.unsubscribe(client)
+	rows := make(map[string]RankedStudent)
+	for _, row := range snapshot {
+		rows[row.RollNumber] = row
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for round := 0; round < 200; round++ {
+		for i := 0; i < 1+rng.Intn(5); i++ {
+			rollNumber := fmt.Sprintf("%010d", rng.Intn(70))
+			student, _ := board.Get(rollNumber)
+			switch {
+			case rng.Intn(10) == 0:
+				ev.remove(rollNumber)
+			default:
+				student.RollNumber = rollNumber
+				student.applyOutcome(ShotOutcome{Runs: rng.Intn(7)})
+				ev.apply(student)
+			}
+		}
+		if err := hub.refresh(); err != nil {
+			t.Fatal(err)
+		}
+
+		for drained := false; !drained; {
+			select {
+			case msg := <-client:
+				applyDiffMessage(t, rows, msg)
+			default:
+				drained = true
+			}
+		}
+		// Diffs only go out when a row's rank, score or name changes
+		got := make(map[string]string)
+		for rollNumber, row := range rows {
+			got[rollNumber] = fmt.Sprintf("%d %d %s", row.Rank, row.Score, row.Name)
+		}
+		want := make(map[string]string)
+		for _, row := range board.Range(0, board.Len()) {
+			want[row.RollNumber] = fmt.Sprintf("%d %d %s", row.Rank, row.Score, row.Name)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("round %d: client rows differ from the board", round)
+		}
+	}
+}
+
+func applyDiffMessage(t *testing.T, rows map[string]RankedStudent, msg []byte) {
+	t.Helper()
+	data := strings.TrimSuffix(strings.SplitN(string(msg), "data: ", 2)[1], "\n\n")
+	var diff scoreboardDiff
+	if err := json.Unmarshal([]byte(data), &diff); err != nil {
+		t.Fatal(err)
+	}
+	for _, rollNumber := range diff.Removed {
+		delete(rows, rollNumber)
+	}
+	for _, change := range diff.Changes {
+		rows[change.RollNumber] = change.RankedStudent
+	}
+	for rollNumber, row := range rows {
+		if row.Rank > diff.Total {
+			delete(rows, rollNumber)
+		}
+	}
+}
+
+// Rows from store writes that finish out of order must not take a player
+// back to an older total, and concurrent writers must leave the board
+// matching the newest row
+func TestApplyKeepsTheNewestRow(t *testing.T) {
+	board := NewLeaderboard()
+	ev := &eventRuntime{leaderboard: board, teams: NewTeamBoard(), hub: newScoreboardHub(board)}
+	const roll = "2021000001"
+
+	ev.apply(Student{RollNumber: roll, Team: "Lions", Score: 10, BallsFaced: 2})
+	ev.apply(Student{RollNumber: roll, Team: "Lions", Score: 4, BallsFaced: 1})
+	if student, _ := board.Get(roll); student.Score != 10 {
+		t.Errorf("older row took the score back to %d", student.Score)
+	}
+	if standings := ev.teams.Standings(false); len(standings) != 1 || standings[0].Runs != 10 {
+		t.Errorf("team board shows %+v after the older row", standings)
+	}
+
+	done := make(chan struct{})
+	for balls := 3; balls <= 50; balls++ {
+		go func(balls int) {
+			ev.apply(Student{RollNumber: roll, Team: "Lions", Score: balls * 2, BallsFaced: balls})
+			done <- struct{}{}
+		}(balls)
+	}
+	for balls := 3; balls <= 50; balls++ {
+		<-done
+	}
+	if student, _ := board.Get(roll); student.BallsFaced != 50 || student.Score != 100 {
+		t.Errorf("board ended on %+v, want the row with 50 balls", student)
+	}
+}
+
+// An event's hub runs while the event can change and stops when it is
+// archived or the server's background context ends
+func TestHubStopsWithItsEvent(t *testing.T) {
+	newTestServer(t, nil)
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	event := Event{ID: "spring", Name: "Spring", StartsAt: time.Now().Add(-time.Hour)}
+	// idle reports whether nothing is left to take a notification off the hub
+	idle := func(ev *eventRuntime) bool {
+		select {
+		case <-ev.hub.notify:
+		default:
+		}
+		ev.hub.wake()
+		time.Sleep(2 * LIVE_MIN_INTERVAL_MS * time.Millisecond)
+		return len(ev.hub.notify) == 1
+	}
+
+	tests := []struct {
+		step    string
+		change  func()
+		running bool
+	}{
+		{"loaded live", func() {}, true},
+		{"archived", func() { event.Archived = true }, false},
+		{"unarchived", func() { event.Archived = false }, true},
+		{"server stopping", stopBackground, false},
+	}
+	for _, tt := range tests {
+		tt.change()
+		if err := eventCatalog.Save(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadEvents(background); err != nil && background.Err() == nil {
+			t.Fatal(err)
+		}
+		ev, _ := getEvent(event.ID)
+		if idle(ev) == tt.running {
+			t.Errorf("%s: hub running %v, want %v", tt.step, !tt.running, tt.running)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+var (
+	// logLevel can be changed while running
+	logLevel = new(slog.LevelVar)
+	logger   = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
+
+	// A caller's X-Request-ID is reused only if it looks like an ID
+	validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+)
+
+// loadLogConfig sets the level from LOG_LEVEL (debug, info, warn or error;
+// default info) and routes the standard log package through slog too
+func loadLogConfig() {
+	level, _ := parseLogLevel(currentConfig().Log.Level)
+	logLevel.Set(level)
+	slog.SetDefault(logger)
+}
+
+func parseLogLevel(raw string) (slog.Level, error) {
+	switch strings.ToLower(raw) {
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("LOG_LEVEL: want debug, info, warn or error, got %q", raw)
+}
+
+// requestLog collects what a request's log line reports. Handlers add to
+// it through the request context.
+type requestLog struct {
+	id string
+
+	mu         sync.Mutex
+	route      string
+	rollNumber string
+	errors     []string
+}
+
+type requestLogKey struct{}
+
+func requestLogFrom(ctx context.Context) *requestLog {
+	entry, _ := ctx.Value(requestLogKey{}).(*requestLog)
+	return entry
+}
+
+// noteRoll records which player the request was about
+func noteRoll(ctx context.Context, rollNumber string) {
+	if entry := requestLogFrom(ctx); entry != nil {
+		entry.mu.Lock()
+		entry.rollNumber = rollNumber
+		entry.mu.Unlock()
+	}
+}
+
+// noteError adds an error to the request's log line. Outside a request it
+// is logged on its own.
+func noteError(ctx context.Context, what string, err error) {
+	message := err.Error()
+	if what != "" {
+		message = what + ": " + message
+	}
+	entry := requestLogFrom(ctx)
+	if entry == nil {
+		logger.Error(message)
+		return
+	}
+	entry.mu.Lock()
+	entry.errors = append(entry.errors, message)
+	entry.mu.Unlock()
+}
+
+// contextLogger tags lines with the request ID, for anything logged in the
+// middle of a request rather than on its summary line
+func contextLogger(ctx context.Context) *slog.Logger {
+	if entry := requestLogFrom(ctx); entry != nil {
+		return logger.With("request_id", entry.id)
+	}
+	return logger
+}
+
+// logRequests wraps the router: it gives every request an ID, echoes it in
+// X-Request-ID and writes one JSON line per request when it finishes
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &requestLog{id: r.Header.Get(REQUEST_ID_HEADER)}
+		if !validRequestID.MatchString(entry.id) {
+			entry.id = newRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, entry.id)
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, entry)))
+
+		level := slog.LevelInfo
+		switch {
+		case recorder.status >= 500:
+			level = slog.LevelError
+		case recorder.status >= 400:
+			level = slog.LevelWarn
+		}
+
+		entry.mu.Lock()
+		attrs := []slog.Attr{
+			slog.String("request_id", entry.id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", entry.route),
+			slog.Int("status", recorder.status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", clientIP(r)),
+		}
+		if entry.rollNumber != "" {
+			attrs = append(attrs, slog.String("roll_number", entry.rollNumber))
+		}
+		if len(entry.errors) > 0 {
+			attrs = append(attrs, slog.String("error", strings.Join(entry.errors, "; ")))
+		}
+		entry.mu.Unlock()
+
+		logger.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// annotateRequestLog runs inside the router, where the matched route and
+// its variables are known
+func annotateRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if entry := requestLogFrom(r.Context()); entry != nil {
+			entry.mu.Lock()
+			if route := mux.CurrentRoute(r); route != nil {
+				entry.route, _ = route.GetPathTemplate()
+			}
+			if rollNumber := mux.Vars(r)["rollNumber"]; rollNumber != "" {
+				entry.rollNumber = rollNumber
+			}
+			entry.mu.Unlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
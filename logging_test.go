@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// logCapture collects log lines written from any goroutine
+type logCapture struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *logCapture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(p)
+}
+
+// requestLines returns the "request" summary lines logged so far
+func (c *logCapture) requestLines(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var lines []map[string]interface{}
+	for _, raw := range strings.Split(strings.TrimSpace(c.buf.String()), "\n") {
+		var line map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("log line %q: %v", raw, err)
+		}
+		if line["msg"] == "request" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestRequestLogFields(t *testing.T) {
+	server := newTestServer(t, nil)
+	capture := &logCapture{}
+	saved := logger
+	logger = slog.New(slog.NewJSONHandler(capture, nil))
+	t.Cleanup(func() { logger = saved })
+
+	generated := regexp.MustCompile(`^[0-9a-f]{16}$`)
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		body      string
+		requestID string // Sent by the caller
+		keepID    bool   // The caller's ID is used rather than a new one
+		want      map[string]interface{}
+	}{
+		{
+			"caller's ID is kept", "POST", "/hit", `{"rollNumber":"2021000001","name":"Asha"}`, "trace-42.a:b", true,
+			map[string]interface{}{"route": "/hit", "status": 200.0, "roll_number": "2021000001"},
+		},
+		{
+			"no ID gets a new one", "GET", "/students/2021000001", "", "", false,
+			map[string]interface{}{"route": "/students/{rollNumber}", "status": 200.0, "roll_number": "2021000001"},
+		},
+		{
+			"malformed ID is replaced", "POST", "/hit", `{"rollNumber":`, "bad id\n", false,
+			map[string]interface{}{"route": "/hit", "status": 400.0, "level": "WARN"},
+		},
+		{
+			"overlong ID is replaced", "GET", "/scoreboard", "", strings.Repeat("a", 129), false,
+			map[string]interface{}{"route": "/scoreboard", "status": 200.0, "path": "/scoreboard"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.requestID != "" {
+				req.Header.Set(REQUEST_ID_HEADER, tt.requestID)
+			}
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, req)
+
+			id := rec.Header().Get(REQUEST_ID_HEADER)
+			if tt.keepID && id != tt.requestID {
+				t.Errorf("echoed ID %q, want the caller's %q", id, tt.requestID)
+			}
+			if !tt.keepID && !generated.MatchString(id) {
+				t.Errorf("echoed ID %q, want a generated one", id)
+			}
+
+			var found map[string]interface{}
+			for _, line := range capture.requestLines(t) {
+				if line["request_id"] == id {
+					found = line
+				}
+			}
+			if found == nil {
+				t.Fatalf("no request line with ID %q", id)
+			}
+			for field, want := range tt.want {
+				if found[field] != want {
+					t.Errorf("%s = %v, want %v", field, found[field], want)
+				}
+			}
+		})
+	}
+
+	// A handler's error ends up on its request's line
+	rec := serveJSON(server, "POST", "/hit", `not json`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad body: got %d, want 400", rec.Code)
+	}
+	for _, line := range capture.requestLines(t) {
+		if line["request_id"] == rec.Header().Get(REQUEST_ID_HEADER) {
+			if errText, _ := line["error"].(string); !strings.Contains(errText, "invalid character") {
+				t.Errorf("error field %q, want the decode error", errText)
+			}
+			return
+		}
+	}
+	t.Error("no request line for the bad body")
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	METRICS_NAMESPACE            = "cricket"
+	ACTIVE_PLAYER_WINDOW_MINUTES = 5 // A player who hit within this long counts as active
+)
+
+// Histogram bucket upper bounds in seconds
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricFamily is one metric in the Prometheus text format with its
+// labelled series. Counters and gauges use value; histograms use the rest.
+type metricFamily struct {
+	name   string
+	help   string
+	kind   string // "counter", "gauge" or "histogram"
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*metricSeries // Keyed by the joined label values
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64 // Per bucket, not cumulative
+	count       uint64
+	sum         float64
+}
+
+var (
+	metricFamilies []*metricFamily
+
+	httpRequests        = newMetric("http_requests_total", "counter", "HTTP requests by route, method and status.", "route", "method", "status")
+	httpRequestDuration = newMetric("http_request_duration_seconds", "histogram", "HTTP request latency by route, method and status.", "route", "method", "status")
+	dbOperationDuration = newMetric("db_operation_duration_seconds", "histogram", "Store operation latency.", "operation", "result")
+	scoreboardLookups   = newMetric("scoreboard_cache_requests_total", "counter", "Scoreboard page cache lookups.", "result")
+	rateLimitRejections = newMetric("rate_limit_rejections_total", "counter", "Requests refused by a rate limiter.", "limiter")
+	rateLimiterKeys     = newMetric("rate_limiter_keys", "gauge", "Buckets held by an in-memory rate limiter.", "limiter")
+	activePlayers       = newMetric("active_players", "gauge", "Players who hit in the last 5 minutes.", "event")
+	streamClients       = newMetric("scoreboard_stream_clients", "gauge", "Connected live scoreboard streams.", "event")
+	journalLag          = newMetric("journal_lag", "gauge", "Journaled hits not yet in the store.")
+)
+
+func newMetric(name, kind, help string, labels ...string) *metricFamily {
+	family := &metricFamily{
+		name:   METRICS_NAMESPACE + "_" + name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*metricSeries),
+	}
+	metricFamilies = append(metricFamilies, family)
+	return family
+}
+
+// get returns the series for the label values; f.mu must be held
+func (f *metricFamily) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == "histogram" {
+			s.buckets = make([]uint64, len(latencyBuckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *metricFamily) Inc(labelValues ...string) {
+	f.mu.Lock()
+	f.get(labelValues).value++
+	f.mu.Unlock()
+}
+
+func (f *metricFamily) Set(value float64, labelValues ...string) {
+	f.mu.Lock()
+	f.get(labelValues).value = value
+	f.mu.Unlock()
+}
+
+// Reset drops every series, for gauges whose label set changes between
+// scrapes
+func (f *metricFamily) Reset() {
+	f.mu.Lock()
+	f.series = make(map[string]*metricSeries)
+	f.mu.Unlock()
+}
+
+func (f *metricFamily) Observe(seconds float64, labelValues ...string) {
+	f.mu.Lock()
+	s := f.get(labelValues)
+	if i := sort.SearchFloat64s(latencyBuckets, seconds); i < len(latencyBuckets) {
+		s.buckets[i]++
+	}
+	s.count++
+	s.sum += seconds
+	f.mu.Unlock()
+}
+
+// observeDB records how long a store operation took
+func observeDB(operation string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	dbOperationDuration.Observe(time.Since(start).Seconds(), operation, result)
+}
+
+func (f *metricFamily) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		labels := formatLabels(f.labels, s.labelValues)
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels, formatFloat(s.value))
+			continue
+		}
+
+		bucketNames := append(append([]string(nil), f.labels...), "le")
+		bucketValues := append(append([]string(nil), s.labelValues...), "")
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += s.buckets[i]
+			bucketValues[len(bucketValues)-1] = formatFloat(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(bucketNames, bucketValues), cumulative)
+		}
+		bucketValues[len(bucketValues)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(bucketNames, bucketValues), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels, s.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// collectGauges samples the gauges that are read rather than counted
+func collectGauges() {
+	for name, limiter := range map[string]RateLimiter{"roll": rollLimiter, "ip": ipLimiter, "login": loginLimiter, "admin": adminLimiter} {
+		if local, ok := limiter.(*TokenBucketLimiter); ok {
+			rateLimiterKeys.Set(float64(local.Len()), name)
+		}
+	}
+
+	activePlayers.Reset()
+	streamClients.Reset()
+	since := time.Now().Add(-ACTIVE_PLAYER_WINDOW_MINUTES * time.Minute)
+	eventRuntimesMu.RLock()
+	for id, ev := range eventRuntimes {
+		active := 0
+		for _, student := range ev.leaderboard.All() {
+			if student.LastPlayed.After(since) {
+				active++
+			}
+		}
+		activePlayers.Set(float64(active), id)
+		streamClients.Set(float64(ev.hub.clientCount()), id)
+	}
+	eventRuntimesMu.RUnlock()
+
+	if hitJournal != nil {
+		journalLag.Set(float64(hitJournal.Status().Lag))
+	}
+}
+
+// getMetrics serves GET /metrics in the Prometheus text format
+func getMetrics(w http.ResponseWriter, r *http.Request) {
+	collectGauges()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buf := bufio.NewWriter(w)
+	for _, family := range metricFamilies {
+		family.write(buf)
+	}
+	buf.Flush()
+}
+
+// instrumentRoutes counts and times every request by its route template,
+// so /students/{rollNumber} is one series rather than one per student
+func instrumentRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		status := strconv.Itoa(recorder.status)
+		httpRequests.Inc(route, r.Method, status)
+		httpRequestDuration.Observe(time.Since(start).Seconds(), route, r.Method, status)
+	})
+}
+
+// statusRecorder remembers the status code a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps the live scoreboard stream working through the wrapper
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMetricsTextFormat(t *testing.T) {
+	counter := &metricFamily{name: "cricket_test_total", help: "A counter.", kind: "counter", labels: []string{"path"}, series: make(map[string]*metricSeries)}
+	counter.Inc(`/a"b`)
+	counter.Inc(`/a"b`)
+	counter.Inc("/c\\d\n")
+
+	gauge := &metricFamily{name: "cricket_test_gauge", help: "A gauge.", kind: "gauge", series: make(map[string]*metricSeries)}
+	gauge.Set(0.25)
+
+	histogram := &metricFamily{name: "cricket_test_seconds", help: "A histogram.", kind: "histogram", labels: []string{"op"}, series: make(map[string]*metricSeries)}
+	histogram.Observe(0.003, "read")
+	histogram.Observe(0.003, "read")
+	histogram.Observe(0.2, "read")
+	histogram.Observe(20, "read") // Past the last bucket, only in +Inf
+
+	tests := []struct {
+		family *metricFamily
+		want   string
+	}{
+		{counter, `# HELP cricket_test_total A counter.
+# TYPE cricket_test_total counter
+cricket_test_total{path="/a\"b"} 2
+cricket_test_total{path="/c\\d\n"} 1
+`},
+		{gauge, `# HELP cricket_test_gauge A gauge.
+# TYPE cricket_test_gauge gauge
+cricket_test_gauge 0.25
+`},
+		{histogram, `# HELP cricket_test_seconds A histogram.
+# TYPE cricket_test_seconds histogram
+cricket_test_seconds_bucket{op="read",le="0.0005"} 0
+cricket_test_seconds_bucket{op="read",le="0.001"} 0
+cricket_test_seconds_bucket{op="read",le="0.0025"} 0
+cricket_test_seconds_bucket{op="read",le="0.005"} 2
+cricket_test_seconds_bucket{op="read",le="0.01"} 2
+cricket_test_seconds_bucket{op="read",le="0.025"} 2
+cricket_test_seconds_bucket{op="read",le="0.05"} 2
+cricket_test_seconds_bucket{op="read",le="0.1"} 2
+cricket_test_seconds_bucket{op="read",le="0.25"} 3
+cricket_test_seconds_bucket{op="read",le="0.5"} 3
+cricket_test_seconds_bucket{op="read",le="1"} 3
+cricket_test_seconds_bucket{op="read",le="2.5"} 3
+cricket_test_seconds_bucket{op="read",le="5"} 3
+cricket_test_seconds_bucket{op="read",le="10"} 3
+cricket_test_seconds_bucket{op="read",le="+Inf"} 4
+cricket_test_seconds_sum{op="read"} 20.206
+cricket_test_seconds_count{op="read"} 4
+`},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		w := bufio.NewWriter(&out)
+		tt.family.write(w)
+		w.Flush()
+		if out.String() != tt.want {
+			t.Errorf("%s:\ngot\n%s\nwant\n%s", tt.family.name, out.String(), tt.want)
+		}
+	}
+}
+
+// metricValue reads one series from a /metrics body, 0 if it is missing
+func metricValue(t *testing.T, body, series string) float64 {
+	t.Helper()
+	for _, line := range strings.Split(body, "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("%s: %v", line, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestMetricsCountRequests(t *testing.T) {
+	server := newTestServer(t, map[string]string{"RATE_LIMIT_ROLL_BURST": "2"})
+	scrape := func() string {
+		t.Helper()
+		rec := serveJSON(server, "GET", "/metrics", "")
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+			t.Fatalf("metrics: %d %s", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		return rec.Body.String()
+	}
+
+	tests := []struct {
+		series string
+		want   float64 // Increase over the requests below
+	}{
+		{`cricket_http_requests_total{route="/hit",method="POST",status="200"}`, 2},
+		{`cricket_http_requests_total{route="/hit",method="POST",status="400"}`, 1},
+		{`cricket_http_requests_total{route="/hit",method="POST",status="429"}`, 1},
+		{`cricket_http_requests_total{route="/students/{rollNumber}",method="GET",status="200"}`, 1},
+		{`cricket_http_request_duration_seconds_count{route="/hit",method="POST",status="200"}`, 2},
+		{`cricket_rate_limit_rejections_total{limiter="roll"}`, 1},
+	}
+	before := scrape()
+	for _, body := range []string{
+		`{"rollNumber":"2021000001","name":"Asha"}`,
+		`{"rollNumber":"2021000001","name":"Asha"}`,
+		`{"rollNumber":"2021000001","name":"Asha"}`, // Over the roll burst
+		`{"rollNumber":"12","name":"Asha"}`,
+	} {
+		serveJSON(server, "POST", "/hit", body)
+	}
+	serveJSON(server, "GET", "/students/2021000001", "")
+	after := scrape()
+
+	for _, tt := range tests {
+		if got := metricValue(t, after, tt.series) - metricValue(t, before, tt.series); got != tt.want {
+			t.Errorf("%s went up by %v, want %v", tt.series, got, tt.want)
+		}
+	}
+
+	// Gauges are sampled at scrape time
+	if got := metricValue(t, after, `cricket_active_players{event="default"}`); got != 1 {
+		t.Errorf("active players %v, want 1", got)
+	}
+	if got := metricValue(t, after, `cricket_rate_limiter_keys{limiter="roll"}`); got < 1 {
+		t.Errorf("roll limiter keys %v, want at least 1", got)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PlayerModeration is what moderators have decided about one roll number.
+// It applies to every event.
+type PlayerModeration struct {
+	RollNumber  string    `json:"rollNumber" bson:"_id"`
+	Banned      bool      `json:"banned" bson:"banned"`
+	BanReason   string    `json:"banReason,omitempty" bson:"banReason,omitempty"`
+	DisplayName string    `json:"displayName,omitempty" bson:"displayName,omitempty"` // Replaces the name sent with hits
+	UpdatedAt   time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+// ModerationStore keeps bans and forced display names
+type ModerationStore interface {
+	// Get returns the roll number's record, or an empty one if moderators
+	// never touched it
+	Get(ctx context.Context, rollNumber string) (*PlayerModeration, error)
+	Save(ctx context.Context, record PlayerModeration) error
+	// Banned lists every banned roll number
+	Banned(ctx context.Context) ([]PlayerModeration, error)
+}
+
+// AuditEntry records one admin action
+type AuditEntry struct {
+	ID         string                 `json:"id" bson:"_id"`
+	Timestamp  time.Time              `json:"timestamp" bson:"timestamp"`
+	Actor      string                 `json:"actor" bson:"actor"`
+	Action     string                 `json:"action" bson:"action"`
+	EventID    string                 `json:"eventId,omitempty" bson:"eventId,omitempty"`
+	RollNumber string                 `json:"rollNumber,omitempty" bson:"rollNumber,omitempty"`
+	Reason     string                 `json:"reason,omitempty" bson:"reason,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty" bson:"details,omitempty"`
+	ClientIP   string                 `json:"clientIp" bson:"clientIp"`
+	Outcome    string                 `json:"outcome" bson:"outcome"` // One of the AUDIT_* outcomes
+	Error      string                 `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// Outcomes of an audited action. An entry is written as pending before the
+// action and finished once it is known how it went; one left pending means
+// the process stopped in between.
+const (
+	AUDIT_PENDING = "pending"
+	AUDIT_DONE    = "done"
+	AUDIT_FAILED  = "failed"
+)
+
+// AuditLog is the append-only record of admin actions
+type AuditLog interface {
+	Append(ctx context.Context, entry AuditEntry) error
+	// Finish records the outcome of the action a pending entry describes
+	Finish(ctx context.Context, id, outcome, errMessage string) error
+	// Recent returns up to limit entries, newest first
+	Recent(ctx context.Context, limit int) ([]AuditEntry, error)
+}
+
+var (
+	moderation ModerationStore
+	auditLog   AuditLog
+)
+
+// newModerationStore opens the bans and forced display names, keyed by
+// roll number
+func newModerationStore() ModerationStore {
+	if records := openCollection("moderation"); records != nil {
+		return &mongoModerationStore{collection: records}
+	}
+	return &memoryModerationStore{records: make(map[string]PlayerModeration)}
+}
+
+// newAuditLog opens the admin audit log, indexed newest first for
+// /admin/audit
+func newAuditLog() AuditLog {
+	if entries := openCollection("audit_log", mongo.IndexModel{Keys: bson.D{{Key: "timestamp", Value: -1}}}); entries != nil {
+		return &mongoAuditLog{collection: entries}
+	}
+	return &memoryAuditLog{}
+}
+
+func newAuditEntry(actor, action, eventID, rollNumber, reason, clientIP string) AuditEntry {
+	return AuditEntry{
+		ID:         primitive.NewObjectID().Hex(),
+		Timestamp:  time.Now(),
+		Actor:      actor,
+		Action:     action,
+		EventID:    eventID,
+		RollNumber: rollNumber,
+		Reason:     reason,
+		ClientIP:   clientIP,
+		Outcome:    AUDIT_PENDING,
+	}
+}
+
+// ---------------------------------------------------------------------------
+// MongoDB moderation and audit log
+// ---------------------------------------------------------------------------
+
+type mongoModerationStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoModerationStore) Get(ctx context.Context, rollNumber string) (*PlayerModeration, error) {
+	record := PlayerModeration{RollNumber: rollNumber}
+	err := s.collection.FindOne(ctx, bson.M{"_id": rollNumber}).Decode(&record)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (s *mongoModerationStore) Save(ctx context.Context, record PlayerModeration) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := s.collection.ReplaceOne(ctx, bson.M{"_id": record.RollNumber}, record, opts)
+	return err
+}
+
+func (s *mongoModerationStore) Banned(ctx context.Context) ([]PlayerModeration, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := s.collection.Find(ctx, bson.M{"banned": true}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	records := []PlayerModeration{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+type mongoAuditLog struct {
+	collection *mongo.Collection
+}
+
+func (l *mongoAuditLog) Append(ctx context.Context, entry AuditEntry) error {
+	_, err := l.collection.InsertOne(ctx, entry)
+	return err
+}
+
+func (l *mongoAuditLog) Finish(ctx context.Context, id, outcome, errMessage string) error {
+	update := bson.M{"$set": bson.M{"outcome": outcome, "error": errMessage}}
+	_, err := l.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+func (l *mongoAuditLog) Recent(ctx context.Context, limit int) ([]AuditEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := l.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []AuditEntry{}
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ---------------------------------------------------------------------------
+// In-memory moderation and audit log
+// ---------------------------------------------------------------------------
+
+type memoryModerationStore struct {
+	mu      sync.RWMutex
+	records map[string]PlayerModeration
+}
+
+func (s *memoryModerationStore) Get(ctx context.Context, rollNumber string) (*PlayerModeration, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, exists := s.records[rollNumber]
+	if !exists {
+		record = PlayerModeration{RollNumber: rollNumber}
+	}
+	return &record, nil
+}
+
+func (s *memoryModerationStore) Save(ctx context.Context, record PlayerModeration) error {
+	s.mu.Lock()
+	s.records[record.RollNumber] = record
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryModerationStore) Banned(ctx context.Context) ([]PlayerModeration, error) {
+	s.mu.RLock()
+	records := []PlayerModeration{}
+	for _, record := range s.records {
+		if record.Banned {
+			records = append(records, record)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool { return records[i].RollNumber < records[j].RollNumber })
+	return records, nil
+}
+
+type memoryAuditLog struct {
+	mu      sync.RWMutex
+	entries []AuditEntry
+}
+
+func (l *memoryAuditLog) Append(ctx context.Context, entry AuditEntry) error {
+	l.mu.Lock()
+	l.entries = append(l.entries, entry)
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *memoryAuditLog) Finish(ctx context.Context, id, outcome, errMessage string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if l.entries[i].ID == id {
+			l.entries[i].Outcome = outcome
+			l.entries[i].Error = errMessage
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *memoryAuditLog) Recent(ctx context.Context, limit int) ([]AuditEntry, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	entries := make([]AuditEntry, 0, limit)
+	for i := len(l.entries) - 1; i >= 0 && len(entries) < limit; i-- {
+		entries = append(entries, l.entries[i])
+	}
+	return entries, nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	NAME_DEFAULT_MAX_LENGTH = 30       // Characters, not bytes
+	NAME_MARKUP_CHARACTERS  = "<>&\"`" // Never allowed, so a name can't smuggle in HTML
+)
+
+// Leetspeak look-alikes mapped back to letters before the blocklist check
+var leetspeak = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s")
+
+// blockedWord is one folded blocklist entry. Entries match whole words
+// only, so "ass" does not reject "Hassan"; an entry written with a leading
+// "*" matches anywhere in a name, which is also the way to block words in
+// scripts written without spaces, such as Chinese or Thai.
+type blockedWord struct {
+	word      string
+	substring bool
+}
+
+// readBlocklist folds the NAME_BLOCKLIST words and those in
+// NAME_BLOCKLIST_FILE (one word per line, # starts a comment)
+func readBlocklist(words []string, path string) ([]blockedWord, error) {
+	var blocklist []blockedWord
+	add := func(entry string) {
+		entry = strings.TrimSpace(entry)
+		substring := strings.HasPrefix(entry, "*")
+		if folded := foldForBlocklist(strings.TrimPrefix(entry, "*")); folded != "" {
+			blocklist = append(blocklist, blockedWord{word: folded, substring: substring})
+		}
+	}
+	for _, word := range words {
+		add(word)
+	}
+	if path == "" {
+		return blocklist, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		add(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return blocklist, nil
+}
+
+// cleanName normalises a display name: NFKC so look-alike and full-width
+// characters become plain ones, control, invisible and markup characters
+// removed, runs of whitespace collapsed. The error message is meant for the
+// player.
+func cleanName(raw string) (string, error) {
+	var b strings.Builder
+	space := false
+	for _, r := range norm.NFKC.String(raw) {
+		switch {
+		case unicode.IsSpace(r):
+			space = b.Len() > 0
+			continue
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r), strings.ContainsRune(NAME_MARKUP_CHARACTERS, r):
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	name := b.String()
+
+	if name == "" {
+		return "", errors.New("Name is required")
+	}
+	if maxLength := currentConfig().Players.NameMaxLength; utf8.RuneCountInString(name) > maxLength {
+		return "", fmt.Errorf("Name must be at most %d characters", maxLength)
+	}
+	if strings.IndexFunc(name, unicode.IsLetter) < 0 {
+		return "", errors.New("Name must contain at least one letter")
+	}
+	return name, nil
+}
+
+// sanitizeName cleans a name typed by a player and checks it against the
+// blocklist. Names from the roster or a moderator skip the blocklist.
+func sanitizeName(raw string) (string, error) {
+	name, err := cleanName(raw)
+	if err != nil {
+		return "", err
+	}
+	if isBlocked(name, currentConfig().Players.blocklist) {
+		return "", errors.New("That name is not allowed. Please choose a different name")
+	}
+	return name, nil
+}
+
+// isBlocked checks a name against the blocklist. The name is split into
+// words at spaces, dashes and underscores and each word is folded. A whole
+// word entry matches a word, or a run of neighbouring words written
+// together, so "B.a.d W0rd" still matches "badword". A substring entry
+// matches anywhere in the folded name.
+func isBlocked(name string, blocklist []blockedWord) bool {
+	var words []string
+	for _, field := range strings.FieldsFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-' || r == '_'
+	}) {
+		if folded := foldForBlocklist(field); folded != "" {
+			words = append(words, folded)
+		}
+	}
+	joined := strings.Join(words, "")
+
+	for _, entry := range blocklist {
+		if entry.substring {
+			if strings.Contains(joined, entry.word) {
+				return true
+			}
+			continue
+		}
+		for start := range words {
+			run := ""
+			for _, word := range words[start:] {
+				run += word
+				if run == entry.word {
+					return true
+				}
+				if len(run) >= len(entry.word) {
+					break
+				}
+			}
+		}
+	}
+	return false
+}
+
+// foldForBlocklist lower-cases, undoes leetspeak and drops everything but
+// letters and the marks that go with them (vowel signs in Indic scripts,
+// for instance), so "B.a.d" and "bad" look the same
+func foldForBlocklist(s string) string {
+	s = leetspeak.Replace(strings.ToLower(norm.NFKC.String(s)))
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsMark(r) {
+			return r
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// useBlocklist makes a config with the given NAME_BLOCKLIST current
+func useBlocklist(t *testing.T, words string) {
+	t.Helper()
+	t.Setenv("STORE_BACKEND", "memory")
+	t.Setenv("NAME_BLOCKLIST", words)
+	cfg, err := loadConfig(configSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeConfig.Store(cfg)
+}
+
+func TestCleanName(t *testing.T) {
+	useBlocklist(t, "")
+	tests := []struct {
+		raw, want, err string
+	}{
+		{"  Asha   Rao ", "Asha Rao", ""},
+		{"Ａｓｈａ", "Asha", ""},               // Full-width letters
+		{"As\u200bha\u202e", "Asha", ""},   // Zero-width space, bidi override
+		{"A<b>sha</b>", "Absha/b", ""},     // Markup characters dropped
+		{"Asha\tRao\nK", "Asha Rao K", ""}, // Any whitespace collapses to one space
+		{"ﬁona", "fiona", ""},              // Ligature decomposed by NFKC
+		{"Σωκράτης", "Σωκράτης", ""},       // Greek
+		{"राहुल शर्मा", "राहुल शर्मा", ""}, // Devanagari with vowel signs
+		{"李小龙", "李小龙", ""},                 // Chinese
+		{"محمد علي", "محمد علي", ""},       // Arabic
+		{"Олег", "Олег", ""},               // Cyrillic
+		{"", "", "Name is required"},
+		{" \u200b ", "", "Name is required"},
+		{"12345", "", "Name must contain at least one letter"},
+		{strings.Repeat("a", 31), "", "Name must be at most 30 characters"},
+		{strings.Repeat("界", 30), strings.Repeat("界", 30), ""}, // Counted in characters, not bytes
+	}
+	for _, test := range tests {
+		got, err := cleanName(test.raw)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("cleanName(%q): got %q, %v; want error %q", test.raw, got, err, test.err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("cleanName(%q) = %q, %v; want %q", test.raw, got, err, test.want)
+		}
+	}
+}
+
+func TestNameBlocklist(t *testing.T) {
+	useBlocklist(t, "ass,badword,cunt,*fuck,गधा,*笨蛋")
+	tests := []struct {
+		name    string
+		allowed bool
+	}{
+		// Whole words only: no Scunthorpe problem
+		{"Hassan", true},
+		{"Cassandra Bassett", true},
+		{"Scunthorpe United", true},
+		{"Ass", false},
+		{"Big Ass", false},
+		{"big-ass", false},
+		{"A$$", false},
+		{"4ss", false},
+		// Spacing and punctuation don't get around a whole word
+		{"Bad Word", false},
+		{"B.a.d W0rd", false},
+		{"b a d w o r d", false},
+		{"bad_word", false},
+		{"badwords", true},
+		// Substring entries match anywhere
+		{"Fuckface", false},
+		{"MotherF.u.c.k.e.r", false},
+		// Folding is applied to the blocklist too
+		{"ＢＡＤＷＯＲＤ", false},
+		// Other scripts
+		{"गधा", false},
+		{"राहुल गधा", false},
+		{"गधाराम", true}, // Whole word entry inside a longer word
+		{"李笨蛋", false},   // No spaces in Chinese, so the entry is a substring one
+		{"李小龙", true},
+		{"Σωκράτης", true},
+	}
+	for _, test := range tests {
+		_, err := sanitizeName(test.name)
+		if allowed := err == nil; allowed != test.allowed {
+			t.Errorf("sanitizeName(%q): allowed = %v, want %v (%v)", test.name, allowed, test.allowed, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default chance of each result, out of the sum of all weights
+const DEFAULT_SHOT_WEIGHTS = "dot=30,1=25,2=12,3=3,4=15,6=8,out=7"
+
+// ShotOutcome is what the server decided a single ball produced
+type ShotOutcome struct {
+	Result string `json:"result" bson:"result"` // dot, 1, 2, 3, 4, 6 or out
+	Runs   int    `json:"runs" bson:"runs"`
+	Out    bool   `json:"out" bson:"out"`
+}
+
+// Every result the engine can produce, keyed by the name used in SHOT_WEIGHTS
+var shotOutcomes = map[string]ShotOutcome{
+	"dot": {Result: "dot", Runs: 0},
+	"1":   {Result: "1", Runs: 1},
+	"2":   {Result: "2", Runs: 2},
+	"3":   {Result: "3", Runs: 3},
+	"4":   {Result: "4", Runs: 4},
+	"6":   {Result: "6", Runs: 6},
+	"out": {Result: "out", Runs: 0, Out: true},
+}
+
+type weightedOutcome struct {
+	outcome ShotOutcome
+	weight  int
+}
+
+// OutcomeEngine rolls a weighted random result for each ball. The client
+// only says "I played a shot"; the runs are always decided here.
+type OutcomeEngine struct {
+	mu      sync.Mutex
+	rng     *rand.Rand
+	weights []weightedOutcome
+	total   int
+}
+
+// NewOutcomeEngine builds an engine from a spec like "dot=30,4=15,out=7".
+// Results missing from the spec can never be rolled.
+func NewOutcomeEngine(seed int64, spec string) (*OutcomeEngine, error) {
+	engine := &OutcomeEngine{rng: rand.New(rand.NewSource(seed))}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, found := strings.Cut(part, "=")
+		if !found {
+			return nil, fmt.Errorf("shot weight %q must look like result=weight", part)
+		}
+		outcome, known := shotOutcomes[strings.TrimSpace(name)]
+		if !known {
+			return nil, fmt.Errorf("unknown shot result %q", name)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("shot weight for %q must be a non-negative integer", name)
+		}
+		if weight == 0 {
+			continue
+		}
+		engine.weights = append(engine.weights, weightedOutcome{outcome: outcome, weight: weight})
+		engine.total += weight
+	}
+
+	if engine.total == 0 {
+		return nil, fmt.Errorf("at least one shot result needs a positive weight")
+	}
+	return engine, nil
+}
+
+// newOutcomeEngineFromConfig uses SHOT_SEED and SHOT_WEIGHTS. Without a
+// seed the engine is seeded from the clock so every run plays differently.
+func newOutcomeEngineFromConfig() *OutcomeEngine {
+	cfg := currentConfig().Game
+	seed := cfg.ShotSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	engine, err := NewOutcomeEngine(seed, cfg.ShotWeights)
+	if err != nil {
+		panic("SHOT_WEIGHTS: " + err.Error())
+	}
+	return engine
+}
+
+// Play rolls the result of one ball
+func (e *OutcomeEngine) Play() ShotOutcome {
+	e.mu.Lock()
+	roll := e.rng.Intn(e.total)
+	e.mu.Unlock()
+
+	for _, w := range e.weights {
+		if roll < w.weight {
+			return w.outcome
+		}
+		roll -= w.weight
+	}
+	// Unreachable while total matches the weights
+	return e.weights[len(e.weights)-1].outcome
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOutcomeDistribution(t *testing.T) {
+	engine, err := NewOutcomeEngine(1, DEFAULT_SHOT_WEIGHTS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const balls = 100000
+	counts := make(map[string]int)
+	for i := 0; i < balls; i++ {
+		outcome := engine.Play()
+		if outcome != shotOutcomes[outcome.Result] {
+			t.Fatalf("ball %d rolled %+v, not one of the known results", i, outcome)
+		}
+		counts[outcome.Result]++
+	}
+
+	// The default weights add up to 100, so each is a percentage
+	tests := []struct {
+		result  string
+		percent float64
+	}{
+		{"dot", 30},
+		{"1", 25},
+		{"2", 12},
+		{"3", 3},
+		{"4", 15},
+		{"6", 8},
+		{"out", 7},
+	}
+	for _, tt := range tests {
+		got := 100 * float64(counts[tt.result]) / balls
+		if math.Abs(got-tt.percent) > 1 {
+			t.Errorf("%s: rolled %.2f%% of balls, want about %.0f%%", tt.result, got, tt.percent)
+		}
+	}
+}
+
+func TestOutcomeEngineSpecs(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr string
+		only    string // The one result every ball must roll
+	}{
+		{"6=1", "", "6"},
+		{" out = 3 , dot=0 ", "", "out"},
+		{"4=1,6=0,", "", "4"},
+		{"dot", "must look like result=weight", ""},
+		{"5=10", "unknown shot result", ""},
+		{"4=-1", "non-negative integer", ""},
+		{"4=lots", "non-negative integer", ""},
+		{"dot=0,out=0", "needs a positive weight", ""},
+		{"", "needs a positive weight", ""},
+	}
+	for _, tt := range tests {
+		engine, err := NewOutcomeEngine(1, tt.spec)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: got %v, want an error containing %q", tt.spec, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.spec, err)
+			continue
+		}
+		for i := 0; i < 100; i++ {
+			if got := engine.Play().Result; got != tt.only {
+				t.Errorf("%q: rolled %s, want only %s", tt.spec, got, tt.only)
+				break
+			}
+		}
+	}
+}
+
+// Older clients still send the shot they picked; the server's roll decides
+func TestHitIgnoresClientShot(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"SHOT_WEIGHTS":          "dot=1",
+		"RATE_LIMIT_ROLL_BURST": "10",
+	})
+
+	bodies := []string{
+		`{"rollNumber":"2021000001","name":"Asha","shot":"6"}`,
+		`{"rollNumber":"2021000001","name":"Asha","shot":6}`,
+		`{"rollNumber":"2021000001","name":"Asha","shot":{"result":"6","runs":6}}`,
+	}
+	for _, body := range bodies {
+		rec := serveJSON(server, "POST", "/hit", body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", body, rec.Code, rec.Body.String())
+		}
+		var hit struct {
+			Outcome ShotOutcome `json:"outcome"`
+			Score   int         `json:"score"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &hit); err != nil {
+			t.Fatal(err)
+		}
+		if hit.Outcome.Result != "dot" || hit.Score != 0 {
+			t.Errorf("%s: rolled %+v for a score of %d, want a dot and 0", body, hit.Outcome, hit.Score)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// StudentProfile is one player's stats plus where they stand on the board.
+// Rank and Percentile are left out for a player the store has but this
+// instance's board doesn't yet, such as one who just played on another
+// instance.
+type StudentProfile struct {
+	Student
+	Rank         int     `json:"rank,omitempty"`
+	TotalPlayers int     `json:"totalPlayers"`
+	Percentile   float64 `json:"percentile,omitempty"` // Share of players ranked at or below this one
+	GapToAbove   *int    `json:"gapToAbove"`           // Runs behind the next rank up; null at the top
+}
+
+// getStudentProfile serves GET /students/{rollNumber}
+func getStudentProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json; charset=UTF-8")
+
+	ev, ok := eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	rollNumber := mux.Vars(r)["rollNumber"]
+	if !validateRollNumber(rollNumber) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": currentConfig().Players.RollNumberMessage})
+		return
+	}
+
+	student, err := profileStudent(r.Context(), ev, rollNumber)
+	if errors.Is(err, ErrStudentNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Student not found"})
+		return
+	}
+	if err != nil {
+		noteError(r.Context(), "", err)
+		http.Error(w, "Error fetching student", http.StatusInternalServerError)
+		return
+	}
+
+	profile := StudentProfile{Student: *student, TotalPlayers: ev.leaderboard.Len()}
+	if rank, ranked := ev.leaderboard.Rank(rollNumber); ranked {
+		profile.Rank = rank
+		share := float64(profile.TotalPlayers-rank+1) / float64(profile.TotalPlayers) * 100
+		profile.Percentile = math.Round(min(max(share, 0), 100)*10) / 10
+	}
+	if above, ok := ev.leaderboard.NextScoreAbove(student.Score); ok {
+		gap := above - student.Score
+		profile.GapToAbove = &gap
+	}
+
+	json.NewEncoder(w).Encode(profile)
+}
+
+// profileStudent reads a student from the store, by the unique rollNumber
+// index. While the board shows hits the store does not have yet, the
+// board's copy is the fresher one, and the only one for a player whose
+// first hits are all still waiting.
+func profileStudent(ctx context.Context, ev *eventRuntime, rollNumber string) (*Student, error) {
+	if ev.hasUnstored() {
+		if student, ok := ev.leaderboard.Get(rollNumber); ok {
+			return &student, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout())
+	defer cancel()
+	return ev.store.GetStudent(ctx, rollNumber)
+}
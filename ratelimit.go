@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const RATE_LIMIT_SWEEP_SECONDS = 30 // How often idle buckets are evicted
+
+// RateLimiter decides whether a key may take one more hit. The in-memory
+// limiter is per process; the Mongo one is shared by every instance and
+// survives restarts.
+type RateLimiter interface {
+	// TryAcquire checks for a token and takes it in one atomic step, so two
+	// concurrent calls for the same key can never both get the last token
+	TryAcquire(ctx context.Context, key string) (RateLimitDecision, error)
+	// Release gives back a token taken by TryAcquire
+	Release(ctx context.Context, key string) error
+	// SetPolicy changes the bucket shape for every key; tokens already in
+	// a bucket are kept
+	SetPolicy(policy RateLimitPolicy)
+}
+
+// RateLimitPolicy is a token bucket shape: Burst tokens at most, refilled at
+// RefillPerSecond. Each hit takes one token.
+type RateLimitPolicy struct {
+	Burst           int     `json:"burst"`
+	RefillPerSecond float64 `json:"refillPerSecond"`
+}
+
+// RateLimitDecision is the outcome of one TryAcquire call
+type RateLimitDecision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration // Until the next token; zero when allowed
+	ResetAfter time.Duration // Until the bucket is full again
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// TokenBucketLimiter keeps one bucket per key. Buckets that have refilled
+// completely carry no state worth keeping, so a background sweep drops them
+// and memory stays bounded by the number of recently active keys.
+type TokenBucketLimiter struct {
+	policy RateLimitPolicy
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func NewTokenBucketLimiter(policy RateLimitPolicy) *TokenBucketLimiter {
+	return &TokenBucketLimiter{policy: policy, buckets: make(map[string]*tokenBucket)}
+}
+
+var (
+	// One bucket per roll number, stops a single player spamming hits
+	rollLimiter RateLimiter
+	// One bucket per client IP, stops one device cycling fake roll numbers
+	ipLimiter RateLimiter
+	// One bucket per roll number that only wrong join codes drain, stops
+	// guessing one player's code from many devices
+	loginLimiter RateLimiter
+	// One bucket per client IP for the admin routes, so moderators and
+	// anyone guessing ADMIN_KEY do not use up players' hit tokens
+	adminLimiter RateLimiter
+)
+
+// loadRateLimitConfig builds the limiters from the config.
+// RATE_LIMIT_BACKEND is "memory" or "mongo" and defaults to the store's
+// backend, so MongoDB deployments share limits across instances.
+func loadRateLimitConfig() {
+	cfg := currentConfig().RateLimit
+	rollPolicy := RateLimitPolicy{Burst: cfg.RollBurst, RefillPerSecond: cfg.RollPerSecond}
+	ipPolicy := RateLimitPolicy{Burst: cfg.IPBurst, RefillPerSecond: cfg.IPPerSecond}
+	loginPolicy := RateLimitPolicy{Burst: cfg.LoginFailureBurst, RefillPerSecond: cfg.LoginFailurePerSecond}
+	adminPolicy := RateLimitPolicy{Burst: cfg.AdminBurst, RefillPerSecond: cfg.AdminPerSecond}
+
+	backend := cfg.Backend
+	if backend == "" {
+		backend = "mongo"
+		if useMemoryBackend() {
+			backend = "memory"
+		}
+	}
+
+	switch backend {
+	case "memory":
+		rollLimiter = NewTokenBucketLimiter(rollPolicy)
+		ipLimiter = NewTokenBucketLimiter(ipPolicy)
+		loginLimiter = NewTokenBucketLimiter(loginPolicy)
+		adminLimiter = NewTokenBucketLimiter(adminPolicy)
+	case "mongo":
+		limits := openRateLimitCollection()
+		rollLimiter = &mongoRateLimiter{collection: limits, prefix: "roll:", policy: rollPolicy}
+		ipLimiter = &mongoRateLimiter{collection: limits, prefix: "ip:", policy: ipPolicy}
+		loginLimiter = &mongoRateLimiter{collection: limits, prefix: "login:", policy: loginPolicy}
+		adminLimiter = &mongoRateLimiter{collection: limits, prefix: "admin:", policy: adminPolicy}
+	}
+	logger.Info("rate limiting", "backend", backend)
+	if len(currentConfig().Server.trustedProxies) == 0 {
+		logger.Warn("TRUSTED_PROXIES is empty, so clients are told apart by socket address only; " +
+			"behind a proxy or load balancer set it, or every player shares one IP rate limit bucket")
+	}
+}
+
+func (l *TokenBucketLimiter) SetPolicy(policy RateLimitPolicy) {
+	l.mu.Lock()
+	l.policy = policy
+	l.mu.Unlock()
+}
+
+// refill tops the bucket up for the time elapsed since it was last touched
+func (l *TokenBucketLimiter) refill(bucket *tokenBucket, now time.Time) {
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens = math.Min(float64(l.policy.Burst), bucket.tokens+elapsed*l.policy.RefillPerSecond)
+	bucket.last = now
+}
+
+// TryAcquire takes a token for key if one is available. The refill, check
+// and take all happen under one lock.
+func (l *TokenBucketLimiter) TryAcquire(ctx context.Context, key string) (RateLimitDecision, error) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: float64(l.policy.Burst), last: now}
+		l.buckets[key] = bucket
+	}
+	l.refill(bucket, now)
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	return l.decision(bucket, allowed), nil
+}
+
+func (l *TokenBucketLimiter) Release(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if bucket, exists := l.buckets[key]; exists {
+		bucket.tokens = math.Min(float64(l.policy.Burst), bucket.tokens+1)
+	}
+	return nil
+}
+
+func (l *TokenBucketLimiter) decision(bucket *tokenBucket, allowed bool) RateLimitDecision {
+	rate := l.policy.RefillPerSecond
+	d := RateLimitDecision{
+		Allowed:    allowed,
+		Limit:      l.policy.Burst,
+		Remaining:  int(math.Floor(bucket.tokens)),
+		ResetAfter: time.Duration((float64(l.policy.Burst) - bucket.tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		d.RetryAfter = time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	return d
+}
+
+// Len is the number of keys currently tracked
+func (l *TokenBucketLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// bucketView is one bucket as shown on the debug pages
+type bucketView struct {
+	Key    string  `json:"key"`
+	Tokens float64 `json:"tokens"` // As of now, after refilling
+}
+
+// Snapshot returns the policy, the number of keys and up to limit of the
+// emptiest buckets, which are the keys being held back
+func (l *TokenBucketLimiter) Snapshot(limit int) (RateLimitPolicy, int, []bucketView) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	views := make([]bucketView, 0, len(l.buckets))
+	for key, bucket := range l.buckets {
+		l.refill(bucket, now)
+		views = append(views, bucketView{Key: key, Tokens: bucket.tokens})
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Tokens < views[j].Tokens })
+	if len(views) > limit {
+		views = views[:limit]
+	}
+	return l.policy, len(l.buckets), views
+}
+
+// sweep drops buckets that would be full by now
+func (l *TokenBucketLimiter) sweep() int {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	evicted := 0
+	for key, bucket := range l.buckets {
+		l.refill(bucket, now)
+		if bucket.tokens >= float64(l.policy.Burst) {
+			delete(l.buckets, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// sweepRateLimiters evicts idle buckets from in-memory limiters until ctx
+// ends. Mongo buckets expire through their TTL index instead.
+func sweepRateLimiters(ctx context.Context) {
+	ticker := time.NewTicker(RATE_LIMIT_SWEEP_SECONDS * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, limiter := range []RateLimiter{rollLimiter, ipLimiter, loginLimiter, adminLimiter} {
+				if local, ok := limiter.(*TokenBucketLimiter); ok {
+					local.sweep()
+				}
+			}
+		}
+	}
+}
+
+// tryAcquireHit takes one token from the player's bucket and one from the
+// device's bucket, or neither: if the device is over its limit the player's
+// token is handed back. limitedBy is "roll" or "ip" when the hit is refused.
+// A limiter that cannot reach its backend lets the hit through rather than
+// blocking play.
+func tryAcquireHit(ctx context.Context, rollNumber, ip string) (decision RateLimitDecision, limitedBy string) {
+	roll, err := rollLimiter.TryAcquire(ctx, rollNumber)
+	if err != nil {
+		noteError(ctx, "Rate limiter", err)
+		roll = RateLimitDecision{Allowed: true}
+	}
+	if !roll.Allowed {
+		rateLimitRejections.Inc("roll")
+		return roll, "roll"
+	}
+
+	device, err := ipLimiter.TryAcquire(ctx, ip)
+	if err != nil {
+		noteError(ctx, "Rate limiter", err)
+		return roll, ""
+	}
+	if !device.Allowed {
+		if roll.Limit > 0 {
+			if err := rollLimiter.Release(ctx, rollNumber); err != nil {
+				noteError(ctx, "Rate limiter release", err)
+			}
+		}
+		rateLimitRejections.Inc("ip")
+		return device, "ip"
+	}
+	return roll, ""
+}
+
+// limitAdmin refuses requests once the client's admin bucket is empty. It
+// guards the admin routes, which have no per-player limit, and slows down
+// anyone guessing ADMIN_KEY.
+func limitAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decision, err := adminLimiter.TryAcquire(r.Context(), clientIP(r))
+		if err != nil {
+			noteError(r.Context(), "Rate limiter", err)
+		} else if !decision.Allowed {
+			rateLimitRejections.Inc("admin")
+			setRateLimitHeaders(w, decision)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Too many requests. Please slow down."})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setRateLimitHeaders publishes a decision so clients can pace themselves
+func setRateLimitHeaders(w http.ResponseWriter, d RateLimitDecision) {
+	if d.Limit == 0 {
+		return // Limiter unavailable, nothing to report
+	}
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(d.ResetAfter)))
+	if !d.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(d.RetryAfter)))
+	}
+}
+
+// ---------------------------------------------------------------------------
+// MongoDB limiter
+// ---------------------------------------------------------------------------
+
+// mongoRateLimiter stores each bucket as a single document using GCRA, the
+// "virtual scheduling" form of a token bucket. A bucket is just its
+// theoretical arrival time (tat): a hit at now is allowed while
+// tat <= now + (burst-1)*interval, and pushes tat to max(tat, now)+interval.
+// Both the check and the push happen in one conditional upsert, with the
+// database clock as "now", so instances never disagree or race.
+type mongoRateLimiter struct {
+	collection *mongo.Collection
+	prefix     string
+
+	mu     sync.RWMutex
+	policy RateLimitPolicy
+}
+
+type rateLimitDoc struct {
+	Key    string    `bson:"_id"`
+	TAT    time.Time `bson:"tat"`
+	SeenAt time.Time `bson:"seenAt"` // Database time of the last allowed hit
+}
+
+// openRateLimitCollection returns the shared limiter collection. Buckets
+// expire once they would be full again, which keeps it small.
+func openRateLimitCollection() *mongo.Collection {
+	return openCollection("rate_limits", mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+}
+
+func (l *mongoRateLimiter) SetPolicy(policy RateLimitPolicy) {
+	l.mu.Lock()
+	l.policy = policy
+	l.mu.Unlock()
+}
+
+// currentPolicy returns the policy and the interval between tokens
+func (l *mongoRateLimiter) currentPolicy() (RateLimitPolicy, time.Duration) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.policy, time.Duration(float64(time.Second) / l.policy.RefillPerSecond)
+}
+
+func (l *mongoRateLimiter) TryAcquire(ctx context.Context, key string) (RateLimitDecision, error) {
+	policy, interval := l.currentPolicy()
+	tolerance := time.Duration(policy.Burst-1) * interval
+	id := l.prefix + key
+
+	filter := bson.M{
+		"_id":   id,
+		"$expr": bson.M{"$lte": bson.A{"$tat", bson.M{"$add": bson.A{"$$NOW", tolerance.Milliseconds()}}}},
+	}
+	nextTAT := bson.M{"$add": bson.A{bson.M{"$max": bson.A{"$tat", "$$NOW"}}, interval.Milliseconds()}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"tat":       nextTAT,
+		"expiresAt": nextTAT,
+		"seenAt":    "$$NOW",
+	}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var doc rateLimitDoc
+	err := l.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&doc)
+	if err == nil {
+		return decideGCRA(policy, interval, doc.TAT, doc.SeenAt, true), nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return RateLimitDecision{}, err
+	}
+
+	// The bucket exists but is empty: the filter missed and the upsert
+	// collided with it. Read it back to say how long to wait.
+	if err := l.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&doc); err != nil {
+		return RateLimitDecision{}, err
+	}
+	return decideGCRA(policy, interval, doc.TAT, time.Now(), false), nil
+}
+
+// Release moves tat back by one interval, returning the token
+func (l *mongoRateLimiter) Release(ctx context.Context, key string) error {
+	_, interval := l.currentPolicy()
+	previousTAT := bson.M{"$subtract": bson.A{"$tat", interval.Milliseconds()}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"tat":       previousTAT,
+		"expiresAt": previousTAT,
+	}}}}
+	_, err := l.collection.UpdateOne(ctx, bson.M{"_id": l.prefix + key}, update)
+	return err
+}
+
+func decideGCRA(policy RateLimitPolicy, interval time.Duration, tat, now time.Time, allowed bool) RateLimitDecision {
+	burst := time.Duration(policy.Burst) * interval
+
+	d := RateLimitDecision{
+		Allowed:    allowed,
+		Limit:      policy.Burst,
+		Remaining:  int(now.Add(burst).Sub(tat) / interval),
+		ResetAfter: tat.Sub(now),
+	}
+	if d.Remaining < 0 {
+		d.Remaining = 0
+	}
+	if d.ResetAfter < 0 {
+		d.ResetAfter = 0
+	}
+	if !allowed {
+		d.RetryAfter = tat.Sub(now) - burst + interval
+		if d.RetryAfter < 0 {
+			d.RetryAfter = 0
+		}
+	}
+	return d
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
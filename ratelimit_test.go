@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const STRESS_CONCURRENCY = 200
+
+// A slow refill keeps the window open for the whole test
+var stressPolicy = RateLimitPolicy{Burst: 1, RefillPerSecond: 0.001}
+
+func TestTryAcquireIsAtomic(t *testing.T) {
+	limiter := NewTokenBucketLimiter(stressPolicy)
+	if allowed := concurrentAcquires(t, limiter); allowed != 1 {
+		t.Fatalf("%d concurrent acquires succeeded, want 1", allowed)
+	}
+}
+
+// Runs the same race against the shared Mongo limiter. Needs a MongoDB at
+// TEST_MONGODB_URI and is skipped without one.
+func TestMongoTryAcquireIsAtomic(t *testing.T) {
+	uri := os.Getenv("TEST_MONGODB_URI")
+	if uri == "" {
+		t.Skip("TEST_MONGODB_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Skipf("MongoDB unreachable: %v", err)
+	}
+	db := client.Database(fmt.Sprintf("cricket_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		db.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	limiter := &mongoRateLimiter{collection: db.Collection("rate_limits"), prefix: "roll:", policy: stressPolicy}
+	if allowed := concurrentAcquires(t, limiter); allowed != 1 {
+		t.Fatalf("%d concurrent acquires succeeded, want 1", allowed)
+	}
+}
+
+// concurrentAcquires races STRESS_CONCURRENCY acquires for one key and
+// returns how many were allowed
+func concurrentAcquires(t *testing.T, limiter RateLimiter) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	start := make(chan struct{})
+	for i := 0; i < STRESS_CONCURRENCY; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			decision, err := limiter.TryAcquire(context.Background(), "2021000001")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if decision.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return allowed
+}
+
+func TestRejectedDeviceReturnsPlayerToken(t *testing.T) {
+	rollLimiter = NewTokenBucketLimiter(stressPolicy)
+	ipLimiter = NewTokenBucketLimiter(stressPolicy)
+	ctx := context.Background()
+
+	if _, limitedBy := tryAcquireHit(ctx, "2021000001", "10.0.0.1"); limitedBy != "" {
+		t.Fatalf("first hit limited by %q", limitedBy)
+	}
+	// Same device, new player: the device is out of tokens
+	if _, limitedBy := tryAcquireHit(ctx, "2021000002", "10.0.0.1"); limitedBy != "ip" {
+		t.Fatalf("second hit limited by %q, want ip", limitedBy)
+	}
+	// The refused hit must not have spent the second player's token
+	if _, limitedBy := tryAcquireHit(ctx, "2021000002", "10.0.0.2"); limitedBy != "" {
+		t.Fatalf("player's own hit limited by %q", limitedBy)
+	}
+}
+
+// Fires concurrent hits for one roll number through the real handler and
+// checks only one of them is scored
+func TestConcurrentHitsScoreOnce(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"RATE_LIMIT_ROLL_BURST":      "1",
+		"RATE_LIMIT_ROLL_PER_SECOND": "0.001",
+		"RATE_LIMIT_IP_BURST":        "1000",
+	})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	codes := make(map[int]int)
+	start := make(chan struct{})
+	for i := 0; i < STRESS_CONCURRENCY; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			rec := serveJSON(server, "POST", "/hit", `{"rollNumber":"2021000001","name":"Stress"}`)
+			mu.Lock()
+			codes[rec.Code]++
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if codes[http.StatusOK] != 1 || codes[http.StatusTooManyRequests] != STRESS_CONCURRENCY-1 {
+		t.Fatalf("got status counts %v, want one 200 and %d 429s", codes, STRESS_CONCURRENCY-1)
+	}
+
+	ev, _ := getEvent(DEFAULT_EVENT_ID)
+	student, err := ev.store.GetStudent(context.Background(), "2021000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if student.BallsFaced != 1 {
+		t.Fatalf("student faced %d balls, want 1", student.BallsFaced)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// Set once a request came through a proxy that TRUSTED_PROXIES does not
+// cover, so the warning is logged once rather than on every request
+var untrustedProxySeen atomic.Bool
+
+// clientIP is the address the rate limits and audit log key on. The
+// socket address is used unless it is one of TRUSTED_PROXIES (Railway's
+// edge, for instance); then X-Forwarded-For is read from the right,
+// skipping trusted hops, and the first other hop is the client. Hops to
+// its left were written by the client and are never believed.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	proxies := currentConfig().Server.trustedProxies
+	if !isTrustedProxy(proxies, host) {
+		if r.Header.Get("X-Forwarded-For") != "" && !untrustedProxySeen.Swap(true) {
+			// Behind a proxy every player then shares the proxy's address,
+			// and with it one IP rate limit bucket
+			contextLogger(r.Context()).Error("request has X-Forwarded-For but came from an untrusted address; "+
+				"all clients behind it share one rate limit bucket, set TRUSTED_PROXIES to the proxy's addresses",
+				"remote_addr", host, "trusted_proxies", currentConfig().Server.TrustedProxies)
+		}
+		return canonicalIP(host)
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrustedProxy(proxies, hop) {
+			return canonicalIP(hop)
+		}
+		host = hop
+	}
+	return canonicalIP(host) // Every hop is a proxy; the leftmost is as close as we get
+}
+
+// canonicalIP writes IPv4-mapped IPv6 addresses as plain IPv4, so one
+// client can't get two rate limit buckets by switching forms
+func canonicalIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	return addr.Unmap().String()
+}
+
+func isTrustedProxy(proxies []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, proxy := range proxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestID is the ID logRequests gave the request, or the caller's
+// X-Request-ID or a new random one outside the middleware
+func requestID(r *http.Request) string {
+	if entry := requestLogFrom(r.Context()); entry != nil {
+		return entry.id
+	}
+	if id := r.Header.Get(REQUEST_ID_HEADER); id != "" {
+		return id
+	}
+	return newRequestID()
+}
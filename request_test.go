@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Setenv("STORE_BACKEND", "memory")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,2001:db8::1")
+	cfg, err := loadConfig(configSource{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeConfig.Store(cfg)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"spoofed header from an untrusted peer", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"one trusted hop", "10.1.2.3:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"client-written hops are ignored", "10.1.2.3:5000", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"multi-hop through trusted proxies", "10.1.2.3:5000", []string{"198.51.100.1, 10.9.9.9, 10.8.8.8"}, "198.51.100.1"},
+		{"hops split over several headers", "10.1.2.3:5000", []string{"1.1.1.1", "198.51.100.1, 10.9.9.9"}, "198.51.100.1"},
+		{"empty hops are skipped", "10.1.2.3:5000", []string{"198.51.100.1, , 10.9.9.9,"}, "198.51.100.1"},
+		{"every hop trusted", "10.1.2.3:5000", []string{"10.4.4.4, 10.5.5.5"}, "10.4.4.4"},
+		{"trusted proxy without the header", "10.1.2.3:5000", nil, "10.1.2.3"},
+		{"IPv4-mapped proxy address", "[::ffff:10.1.2.3]:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"IPv4-mapped hop", "10.1.2.3:5000", []string{"198.51.100.1, ::ffff:10.9.9.9"}, "198.51.100.1"},
+		{"IPv4-mapped client", "10.1.2.3:5000", []string{"::ffff:198.51.100.1"}, "198.51.100.1"},
+		{"IPv4-mapped direct client", "[::ffff:203.0.113.7]:5000", nil, "203.0.113.7"},
+		{"IPv6 proxy", "[2001:db8::1]:5000", []string{"2001:db8::42"}, "2001:db8::42"},
+		{"IPv6 peer outside the list", "[2001:db8::2]:5000", []string{"198.51.100.1"}, "2001:db8::2"},
+		{"garbage hop is the client", "10.1.2.3:5000", []string{"not-an-ip"}, "not-an-ip"},
+		{"remote address without a port", "203.0.113.7", nil, "203.0.113.7"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = test.remoteAddr
+			for _, value := range test.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(req); got != test.want {
+				t.Fatalf("clientIP = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
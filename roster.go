@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ROSTER_MAX_UPLOAD_BYTES = 5 << 20
+	ROSTER_MAX_REPORTED     = 10 // Problem entries named in a startup error
+)
+
+// ErrNotRegistered is returned when a roll number is not on the roster
+var ErrNotRegistered = errors.New("roll number is not registered")
+
+// RosterEntry is one registered student. The roster is shared by every
+// event.
+type RosterEntry struct {
+	RollNumber string `json:"rollNumber" bson:"_id"`
+	Name       string `json:"name" bson:"name"`
+	Team       string `json:"team,omitempty" bson:"team,omitempty"`
+	Year       int    `json:"year,omitempty" bson:"year,omitempty"`
+}
+
+// Roster holds the registered students
+type Roster interface {
+	// Get returns one entry or ErrNotRegistered
+	Get(ctx context.Context, rollNumber string) (*RosterEntry, error)
+	// Import upserts the entries. With replace set, anyone not in the list
+	// is removed.
+	Import(ctx context.Context, entries []RosterEntry, replace bool) error
+	// List returns every entry sorted by roll number
+	List(ctx context.Context) ([]RosterEntry, error)
+	Count(ctx context.Context) (int64, error)
+}
+
+var (
+	roster Roster
+	// With ROSTER_STRICT on, /hit only accepts roll numbers on the roster
+	// and always uses the roster name
+	rosterStrict bool
+)
+
+// newRoster opens the registered students, keyed by roll number
+func newRoster() Roster {
+	if entries := openCollection("roster"); entries != nil {
+		return &mongoRoster{collection: entries}
+	}
+	return &memoryRoster{entries: make(map[string]RosterEntry)}
+}
+
+// loadRosterConfig takes ROSTER_STRICT from the config
+func loadRosterConfig() {
+	rosterStrict = currentConfig().Players.RosterStrict
+	if rosterStrict {
+		logger.Info("roster strict mode on: only registered roll numbers can play")
+	}
+}
+
+// checkRosterTeams makes sure every team on the roster is one of teams.
+// A registered player whose team is not listed would get a 400 on every
+// hit, so the server refuses to start, and a reload is refused, rather
+// than run with such a roster.
+func checkRosterTeams(ctx context.Context, teams []string) error {
+	if len(teams) == 0 {
+		return nil
+	}
+	entries, err := roster.List(ctx)
+	if err != nil {
+		return fmt.Errorf("reading roster: %w", err)
+	}
+
+	var problems []string
+	unknown := 0
+	for _, entry := range entries {
+		if entry.Team == "" || containsFold(teams, entry.Team) {
+			continue
+		}
+		unknown++
+		if len(problems) < ROSTER_MAX_REPORTED {
+			problems = append(problems, fmt.Sprintf("%s is on team %q", entry.RollNumber, entry.Team))
+		}
+	}
+	if unknown == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d roster entries have a team not in TEAM_NAMES (%s): %s",
+		unknown, strings.Join(teams, ", "), strings.Join(problems, "; "))
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, strings.TrimSpace(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupPlayer finds the roster entry for a hit. Outside strict mode it is
+// only consulted when team mode needs the registered team, and a missing
+// entry is not an error.
+func lookupPlayer(ctx context.Context, rollNumber string) (*RosterEntry, error) {
+	if !rosterStrict && len(teamNames) == 0 {
+		return nil, nil
+	}
+	entry, err := roster.Get(ctx, rollNumber)
+	if err == ErrNotRegistered && !rosterStrict {
+		return nil, nil
+	}
+	return entry, err
+}
+
+// parseRoster reads a roster upload. CSV needs a header row naming the
+// rollNumber and name columns; team and year are optional. JSON is an
+// array of RosterEntry. Every row is checked and all problems are reported
+// together so a bad file is never half imported.
+func parseRoster(r io.Reader, format string) ([]RosterEntry, []string, error) {
+	var entries []RosterEntry
+	var lines []int // Source line of each entry, for error messages
+	var problems []string
+
+	switch format {
+	case "csv":
+		reader := csv.NewReader(r)
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(records) == 0 {
+			return nil, nil, errors.New("CSV is empty")
+		}
+
+		columns := make(map[string]int)
+		for i, heading := range records[0] {
+			key := strings.ToLower(strings.NewReplacer(" ", "", "_", "").Replace(heading))
+			columns[key] = i
+		}
+		rollColumn, hasRoll := columns["rollnumber"]
+		nameColumn, hasName := columns["name"]
+		if !hasRoll || !hasName {
+			return nil, nil, errors.New("CSV header must include rollNumber and name")
+		}
+		field := func(record []string, key string) string {
+			if i, ok := columns[key]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		for n, record := range records[1:] {
+			line := n + 2
+			entry := RosterEntry{
+				RollNumber: strings.TrimSpace(record[rollColumn]),
+				Name:       strings.TrimSpace(record[nameColumn]),
+				Team:       field(record, "team"),
+			}
+			if year := field(record, "year"); year != "" {
+				if entry.Year, err = strconv.Atoi(year); err != nil {
+					problems = append(problems, fmt.Sprintf("entry %d: year must be a number", line))
+				}
+			}
+			entries = append(entries, entry)
+			lines = append(lines, line)
+		}
+	case "json":
+		if err := json.NewDecoder(r).Decode(&entries); err != nil {
+			return nil, nil, err
+		}
+		for i := range entries {
+			entries[i].RollNumber = strings.TrimSpace(entries[i].RollNumber)
+			entries[i].Name = strings.TrimSpace(entries[i].Name)
+			entries[i].Team = strings.TrimSpace(entries[i].Team)
+			lines = append(lines, i+1)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported roster format %q", format)
+	}
+
+	if len(entries) == 0 {
+		return nil, nil, errors.New("roster has no students")
+	}
+	problems = append(problems, validateRoster(entries, lines)...)
+	return entries, problems, nil
+}
+
+// validateRoster checks roll numbers, names, duplicates and, in team mode,
+// that every team is one of TEAM_NAMES
+func validateRoster(entries []RosterEntry, lines []int) []string {
+	var problems []string
+	seen := make(map[string]int)
+	for i := range entries {
+		entry := &entries[i]
+		where := fmt.Sprintf("entry %d", lines[i])
+		if !validateRollNumber(entry.RollNumber) {
+			problems = append(problems, where+": "+currentConfig().Players.RollNumberMessage)
+			continue
+		}
+		if first, dup := seen[entry.RollNumber]; dup {
+			problems = append(problems, fmt.Sprintf("%s: roll number %s already listed at entry %d", where, entry.RollNumber, first))
+			continue
+		}
+		seen[entry.RollNumber] = lines[i]
+		if name, err := cleanName(entry.Name); err != nil {
+			problems = append(problems, where+": "+err.Error())
+		} else {
+			entry.Name = name
+		}
+		if entry.Team != "" && len(teamNames) > 0 {
+			team, err := assignTeam(entry.RollNumber, entry.Team)
+			if err != nil {
+				problems = append(problems, where+": "+err.Error())
+			}
+			entry.Team = team // Canonical spelling
+		}
+		if entry.Year < 0 {
+			problems = append(problems, where+": year must be positive")
+		}
+	}
+	return problems
+}
+
+// rosterFormat picks "csv" or "json" from a content type or file name
+func rosterFormat(hint string) string {
+	hint = strings.ToLower(hint)
+	switch {
+	case strings.Contains(hint, "csv"):
+		return "csv"
+	case strings.Contains(hint, "json"):
+		return "json"
+	}
+	return ""
+}
+
+// importRoster handles POST /admin/roster. The body is CSV (text/csv) or
+// JSON (application/json); ?replace=true drops anyone not in the upload.
+func importRoster(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	format := rosterFormat(r.Header.Get("Content-Type"))
+	if format == "" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Send the roster as text/csv or application/json"})
+		return
+	}
+	replace := r.URL.Query().Get("replace") == "true"
+
+	entries, problems, err := parseRoster(http.MaxBytesReader(w, r.Body, ROSTER_MAX_UPLOAD_BYTES), format)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid roster: " + err.Error()})
+		return
+	}
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "Invalid roster", "problems": problems})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	details := map[string]interface{}{"entries": len(entries), "replace": replace}
+	action, ok := audit(ctx, w, r, "import-roster", "", "", "", details)
+	if !ok {
+		return
+	}
+	err = roster.Import(ctx, entries, replace)
+	action.finish(err)
+	if err != nil {
+		noteError(r.Context(), "Roster import", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Error saving roster"})
+		return
+	}
+	total, err := roster.Count(ctx)
+	if err != nil {
+		noteError(r.Context(), "Roster count", err)
+	}
+	contextLogger(r.Context()).Info("roster import", "entries", len(entries), "replace", replace)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported": len(entries),
+		"total":    total,
+	})
+}
+
+// importRosterCommand runs `cricket import-roster [-replace] <file.csv|file.json>`
+func importRosterCommand(args []string) {
+	flags := flag.NewFlagSet("import-roster", flag.ExitOnError)
+	replace := flags.Bool("replace", false, "remove students not in the file")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("usage: cricket import-roster [-replace] <file.csv|file.json>")
+		os.Exit(2)
+	}
+
+	path := flags.Arg(0)
+	format := rosterFormat(filepath.Ext(path))
+	if format == "" {
+		fmt.Println("Roster file must end in .csv or .json")
+		os.Exit(2)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	loadTeamConfig()
+	entries, problems, err := parseRoster(file, format)
+	if err != nil {
+		fmt.Println("Invalid roster:", err.Error())
+		os.Exit(1)
+	}
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		os.Exit(1)
+	}
+
+	initBackend()
+	roster = newRoster()
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+
+	if err := roster.Import(ctx, entries, *replace); err != nil {
+		fmt.Println("Saving roster:", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %d students\n", len(entries))
+}
+
+// ---------------------------------------------------------------------------
+// MongoDB roster
+// ---------------------------------------------------------------------------
+
+type mongoRoster struct {
+	collection *mongo.Collection
+}
+
+func (ro *mongoRoster) Get(ctx context.Context, rollNumber string) (*RosterEntry, error) {
+	var entry RosterEntry
+	err := ro.collection.FindOne(ctx, bson.M{"_id": rollNumber}).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotRegistered
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (ro *mongoRoster) Import(ctx context.Context, entries []RosterEntry, replace bool) error {
+	keep := make([]string, 0, len(entries))
+	models := make([]mongo.WriteModel, 0, len(entries))
+	for _, entry := range entries {
+		keep = append(keep, entry.RollNumber)
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"_id": entry.RollNumber}).
+			SetReplacement(entry).
+			SetUpsert(true))
+	}
+
+	if len(models) > 0 {
+		if _, err := ro.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+	if !replace {
+		return nil
+	}
+	_, err := ro.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$nin": keep}})
+	return err
+}
+
+func (ro *mongoRoster) List(ctx context.Context) ([]RosterEntry, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := ro.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var entries []RosterEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (ro *mongoRoster) Count(ctx context.Context) (int64, error) {
+	return ro.collection.CountDocuments(ctx, bson.M{})
+}
+
+// ---------------------------------------------------------------------------
+// In-memory roster
+// ---------------------------------------------------------------------------
+
+type memoryRoster struct {
+	mu      sync.RWMutex
+	entries map[string]RosterEntry
+}
+
+func (ro *memoryRoster) Get(ctx context.Context, rollNumber string) (*RosterEntry, error) {
+	ro.mu.RLock()
+	defer ro.mu.RUnlock()
+
+	entry, exists := ro.entries[rollNumber]
+	if !exists {
+		return nil, ErrNotRegistered
+	}
+	return &entry, nil
+}
+
+func (ro *memoryRoster) Import(ctx context.Context, entries []RosterEntry, replace bool) error {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+
+	if replace {
+		ro.entries = make(map[string]RosterEntry, len(entries))
+	}
+	for _, entry := range entries {
+		ro.entries[entry.RollNumber] = entry
+	}
+	return nil
+}
+
+func (ro *memoryRoster) List(ctx context.Context) ([]RosterEntry, error) {
+	ro.mu.RLock()
+	defer ro.mu.RUnlock()
+
+	entries := make([]RosterEntry, 0, len(ro.entries))
+	for _, entry := range ro.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].RollNumber < entries[j].RollNumber })
+	return entries, nil
+}
+
+func (ro *memoryRoster) Count(ctx context.Context) (int64, error) {
+	ro.mu.RLock()
+	defer ro.mu.RUnlock()
+	return int64(len(ro.entries)), nil
+}
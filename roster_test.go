@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRosterTeamsMustBeListed(t *testing.T) {
+	newTestServer(t, map[string]string{"TEAM_NAMES": "Red,Blue"})
+	ctx := context.Background()
+	entries := []RosterEntry{
+		{RollNumber: "2021000001", Name: "Asha", Team: "blue"},
+		{RollNumber: "2021000002", Name: "Ravi"},
+		{RollNumber: "2021000003", Name: "Meera", Team: "Green"},
+	}
+	if err := roster.Import(ctx, entries, true); err != nil {
+		t.Fatal(err)
+	}
+
+	err := checkRosterTeams(ctx, []string{"Red", "Blue"})
+	if err == nil || !strings.Contains(err.Error(), "2021000003") || strings.Contains(err.Error(), "2021000001") {
+		t.Fatalf("got %v, want only 2021000003 reported", err)
+	}
+	if err := checkRosterTeams(ctx, []string{"Red", "Blue", "green"}); err != nil {
+		t.Fatalf("teams match case-insensitively, got %v", err)
+	}
+	if err := checkRosterTeams(ctx, nil); err != nil {
+		t.Fatalf("team mode off, got %v", err)
+	}
+
+	// A reload that would drop a rostered team is refused
+	t.Setenv("TEAM_NAMES", "Red,Blue")
+	if err := reloadConfig(); err == nil {
+		t.Fatal("reload accepted TEAM_NAMES without Green")
+	}
+	t.Setenv("TEAM_NAMES", "Red,Blue,Green")
+	if err := reloadConfig(); err != nil {
+		t.Fatalf("reload with every team listed failed: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const SHUTDOWN_DEFAULT_TIMEOUT_SECONDS = 20
+
+// serverClosing is closed when shutdown starts so long-lived streams end
+// instead of holding the drain open until the deadline
+var serverClosing = make(chan struct{})
+
+// draining is set as soon as shutdown starts, before the servers stop
+// accepting connections, so /readyz takes the instance out of rotation
+// while it can still answer
+var draining atomic.Bool
+
+// serve runs the servers until SIGINT or SIGTERM, then shuts down in
+// order: stop accepting connections and let in-flight requests finish
+// (SHUTDOWN_TIMEOUT_SECONDS, default 20), stop background loops and the
+// live scoreboard hubs, flush journaled hits and disconnect from Mongo. It
+// returns the exit code.
+func serve(servers []*http.Server, stopBackground context.CancelFunc) int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	failed := make(chan error, len(servers))
+	for _, server := range servers {
+		server.RegisterOnShutdown(closeStreams)
+		go func(server *http.Server) {
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				failed <- fmt.Errorf("%s: %w", server.Addr, err)
+			}
+		}(server)
+	}
+
+	code := 0
+	var sig os.Signal
+	select {
+	case sig = <-signals:
+	case err := <-failed:
+		logger.Error("server stopped", "error", err.Error())
+		code = 1
+	}
+
+	draining.Store(true)
+
+	// Read now rather than at startup, since SIGHUP may have changed it
+	timeout := time.Duration(currentConfig().Server.ShutdownTimeoutSeconds) * time.Second
+	if sig != nil {
+		logger.Info("shutting down", "signal", sig.String(), "timeout", timeout.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Error("draining requests failed", "addr", server.Addr, "error", err.Error())
+			server.Close()
+			code = 1
+		}
+	}
+	logger.Info("in-flight requests drained")
+
+	stopBackground()
+
+	// The drain may have used up the deadline, so the flush and disconnect
+	// get their own
+	ctx, cancel = context.WithTimeout(context.Background(), BATCH_FLUSH_TIMEOUT_SECS*time.Second)
+	defer cancel()
+
+	// Every hit that got an answer is in the journal; get what we can
+	// into the store now
+	if hitJournal != nil {
+		if err := closeJournal(ctx); err != nil {
+			logger.Error("journal flush failed, unflushed hits are replayed on the next start", "error", err.Error())
+			code = 1
+		} else {
+			logger.Info("journaled hits flushed")
+		}
+	}
+
+	if mongoClient != nil {
+		if err := mongoClient.Disconnect(ctx); err != nil {
+			logger.Error("disconnecting from MongoDB failed", "error", err.Error())
+			code = 1
+		} else {
+			logger.Info("disconnected from MongoDB")
+		}
+	}
+	return code
+}
+
+var closeStreamsOnce sync.Once
+
+func closeStreams() {
+	closeStreamsOnce.Do(func() { close(serverClosing) })
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address nothing is listening on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+// waitFor polls until done reports true or a few seconds pass
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// On SIGTERM serve leaves rotation, lets the in-flight request finish,
+// stops the background loops and only then flushes the journaled hits
+func TestServeDrainsInOrder(t *testing.T) {
+	tests := []struct {
+		name            string
+		timeout         string
+		releaseInFlight bool // Let the in-flight request finish within the deadline
+		wantCode        int
+	}{
+		{"drained in time", "5", true, 0},
+		{"drain deadline passed", "1", false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestServer(t, map[string]string{
+				"HIT_JOURNAL":              "true",
+				"HIT_BATCHING":             "true",
+				"BATCH_FLUSH_MS":           "3600000", // Only shutdown flushes
+				"SHUTDOWN_TIMEOUT_SECONDS": tt.timeout,
+			})
+			t.Cleanup(func() { draining.Store(false) })
+			if rec := serveJSON(router, "POST", "/hit", `{"rollNumber":"2021000001","name":"Asha"}`); rec.Code != http.StatusOK {
+				t.Fatalf("hit: %d %s", rec.Code, rec.Body.String())
+			}
+			ev, _ := getEvent(DEFAULT_EVENT_ID)
+			if _, err := ev.store.GetStudent(context.Background(), "2021000001"); err != ErrStudentNotFound {
+				t.Fatalf("hit reached the store before shutdown: %v", err)
+			}
+
+			var mu sync.Mutex
+			var steps []string
+			step := func(name string) {
+				mu.Lock()
+				steps = append(steps, name)
+				mu.Unlock()
+			}
+			entered, release := make(chan struct{}), make(chan struct{})
+			handler := http.NewServeMux()
+			handler.Handle("/", router)
+			handler.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+				close(entered)
+				<-release
+				step("request finished")
+			})
+
+			// A spare keep-alive connection would hold the drain open
+			client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+			addr := freeAddr(t)
+			code := make(chan int)
+			go func() {
+				code <- serve([]*http.Server{{Addr: addr, Handler: handler}}, func() { step("background stopped") })
+			}()
+			waitFor(t, "the server to listen", func() bool {
+				resp, err := client.Get("http://" + addr + "/healthz")
+				if err == nil {
+					resp.Body.Close()
+				}
+				return err == nil
+			})
+
+			go client.Get("http://" + addr + "/slow")
+			<-entered
+			if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+				t.Fatal(err)
+			}
+			waitFor(t, "readiness to flip", draining.Load)
+			step("out of rotation")
+			if tt.releaseInFlight {
+				close(release)
+			}
+
+			if got := <-code; got != tt.wantCode {
+				t.Errorf("exit code %d, want %d", got, tt.wantCode)
+			}
+			if !tt.releaseInFlight {
+				close(release)
+			}
+			hitJournal, hitBatcher = nil, nil // serve closed them
+
+			want := []string{"out of rotation", "request finished", "background stopped"}
+			if !tt.releaseInFlight {
+				want = []string{"out of rotation", "background stopped"}
+			}
+			mu.Lock()
+			got := append([]string(nil), steps...)
+			mu.Unlock()
+			if len(got) < len(want) || !reflect.DeepEqual(got[:len(want)], want) {
+				t.Errorf("shutdown steps %v, want %v", got, want)
+			}
+
+			// The batched hit was flushed on the way out, even after a
+			// missed drain deadline
+			if _, err := ev.store.GetStudent(context.Background(), "2021000001"); err != nil {
+				t.Errorf("batched hit not flushed: %v", err)
+			}
+		})
+	}
+}
+
+func TestServeFailsWhenItCannotListen(t *testing.T) {
+	newTestServer(t, nil)
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	stopped := false
+	code := serve([]*http.Server{{Addr: taken.Addr().String(), Handler: http.NotFoundHandler()}}, func() { stopped = true })
+	if code != 1 || !stopped {
+		t.Errorf("exit code %d with background stopped %v, want 1 and stopped", code, stopped)
+	}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrStudentNotFound is returned when a roll number has no score yet
+var ErrStudentNotFound = errors.New("student not found")
+
+// ScoreStore is where student scores live. Handlers only talk to this
+// interface so the server can run against MongoDB or entirely in memory.
+type ScoreStore interface {
+	// RecordShot adds the ball's runs to the student (creating them on
+	// first hit) and returns the updated record
+	RecordShot(ctx context.Context, ball BallEvent) (*Student, error)
+	// Leaderboard returns every student sorted by score, highest first
+	Leaderboard(ctx context.Context) ([]Student, error)
+	// GetStudent returns a single student or ErrStudentNotFound
+	GetStudent(ctx context.Context, rollNumber string) (*Student, error)
+	// ReplaceAll overwrites every student with the given totals and
+	// applied balls, removing anyone not in the list
+	ReplaceAll(ctx context.Context, students []Student) error
+	// AdjustScore adds runs (which may be negative) without counting a ball
+	AdjustScore(ctx context.Context, rollNumber string, runs int) (*Student, error)
+	// Rename changes the student's display name
+	Rename(ctx context.Context, rollNumber, name string) (*Student, error)
+	// Delete removes the student or returns ErrStudentNotFound
+	Delete(ctx context.Context, rollNumber string) error
+	// ApplyBatch adds the deltas in order in one round trip. Each delta is
+	// applied whole or not at all, and one with any ball the student
+	// already has is skipped, so writing a batch again after a crash does
+	// not count it twice. Replays that cannot tell how far a batch got
+	// should use one ball per delta.
+	ApplyBatch(ctx context.Context, deltas []StudentDelta) error
+}
+
+// How long a student remembers a ball it counted, so ApplyBatch can tell
+// a replay from a new ball. A ball is only replayed if the process stopped
+// after writing it but before saving the journal checkpoint, and the next
+// start replays it at once; a day leaves room for a crash loop.
+const APPLIED_BALLS_RETENTION = 24 * time.Hour
+
+// appliedBall is one ball a student has counted, and when
+type appliedBall struct {
+	ID string    `bson:"id"`
+	At time.Time `bson:"at"`
+}
+
+// Reads leave out the applied balls
+var withoutAppliedBalls = bson.M{"appliedBalls": 0}
+
+// StudentDelta is the sum of one student's balls in a batch
+type StudentDelta struct {
+	RollNumber  string
+	Name        string // From the latest ball
+	Team        string // Only used if the student is new
+	LastPlayed  time.Time
+	LastOutcome string
+	Runs        int
+	Balls       int
+	Fours       int
+	Sixes       int
+	BallIDs     []string
+}
+
+// add folds one more ball into the delta
+func (d *StudentDelta) add(ball BallEvent) {
+	if d.Balls == 0 {
+		d.RollNumber = ball.RollNumber
+		d.Team = ball.Team
+	}
+	var student Student
+	student.applyOutcome(ball.Shot)
+	d.Runs += student.Score
+	d.Balls += student.BallsFaced
+	d.Fours += student.Fours
+	d.Sixes += student.Sixes
+	d.Name = ball.Name
+	d.LastPlayed = ball.Timestamp
+	d.LastOutcome = ball.Shot.Result
+	d.BallIDs = append(d.BallIDs, ball.ID)
+}
+
+// useMemoryBackend reports whether STORE_BACKEND selects the in-memory
+// backend ("memory") rather than MongoDB ("mongo", the default, so existing
+// deployments behave the same)
+func useMemoryBackend() bool {
+	return currentConfig().Store.Backend == "memory"
+}
+
+// initBackend connects to MongoDB unless the in-memory backend is selected
+func initBackend() {
+	if useMemoryBackend() {
+		logger.Warn("using the in-memory backend, scores are lost on restart")
+		return
+	}
+	initDB() // Uses MongoDB's built-in connection pooling (default: 100)
+}
+
+// openCollection returns a collection of the Mongo database with its
+// indexes in place, or nil on the in-memory backend so the caller can fall
+// back to its in-memory version. A failed index build is logged rather
+// than fatal: the collection works, just without that index.
+func openCollection(name string, indexes ...mongo.IndexModel) *mongo.Collection {
+	if useMemoryBackend() {
+		return nil
+	}
+	collection := mongoDatabase().Collection(name)
+	if len(indexes) == 0 {
+		return collection
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	defer cancel()
+	if _, err := collection.Indexes().CreateMany(ctx, indexes); err != nil {
+		logger.Error("index creation failed", "collection", name, "error", err.Error())
+	}
+	return collection
+}
+
+// newScoreStore opens one event's students, unique by roll number
+func newScoreStore(eventID string) ScoreStore {
+	students := openCollection(studentsCollectionName(eventID), mongo.IndexModel{
+		Keys:    bson.D{{Key: "rollNumber", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if students != nil {
+		return &mongoStore{collection: students}
+	}
+	return newMemoryStore()
+}
+
+// applyOutcome adds one ball to the student's running totals
+func (s *Student) applyOutcome(outcome ShotOutcome) {
+	s.Score += outcome.Runs
+	s.BallsFaced++
+	switch outcome.Runs {
+	case 4:
+		s.Fours++
+	case 6:
+		s.Sixes++
+	}
+	s.LastOutcome = outcome.Result
+}
+
+// ---------------------------------------------------------------------------
+// MongoDB store
+// ---------------------------------------------------------------------------
+
+type mongoStore struct {
+	collection *mongo.Collection
+}
+
+// studentsCollectionName keeps the default event in the original "students"
+// collection so existing data carries over; other events get their own
+func studentsCollectionName(eventID string) string {
+	name := currentConfig().Store.StudentsCollection
+	if eventID == DEFAULT_EVENT_ID {
+		return name
+	}
+	return name + "_" + eventID
+}
+
+func (s *mongoStore) RecordShot(ctx context.Context, ball BallEvent) (*Student, error) {
+	// Upsert: update if exists, insert if not. The team is fixed on the
+	// first hit so players cannot switch sides mid-event.
+	var delta StudentDelta
+	delta.add(ball)
+	filter := bson.M{"rollNumber": ball.RollNumber}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After).SetProjection(withoutAppliedBalls)
+
+	var student Student
+	if err := s.collection.FindOneAndUpdate(ctx, filter, deltaUpdate(delta), opts).Decode(&student); err != nil {
+		return nil, err
+	}
+	return &student, nil
+}
+
+// deltaUpdate is the update pipeline that adds a delta to a student,
+// remembers its balls and forgets those applied more than
+// APPLIED_BALLS_RETENTION ago, so the list stays as long as a day's play.
+// Strings go in as $literal so a name starting with "$" is not read as a
+// field. Pipeline updates need MongoDB 4.2.
+func deltaUpdate(delta StudentDelta) mongo.Pipeline {
+	add := func(field string, n int) bson.M {
+		return bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$" + field, 0}}, n}}
+	}
+	recent := bson.M{"$filter": bson.M{
+		"input": bson.M{"$ifNull": bson.A{"$appliedBalls", bson.A{}}},
+		"cond":  bson.M{"$gte": bson.A{"$$this.at", bson.M{"$subtract": bson.A{"$$NOW", APPLIED_BALLS_RETENTION.Milliseconds()}}}},
+	}}
+	added := make(bson.A, len(delta.BallIDs))
+	for i, id := range delta.BallIDs {
+		added[i] = bson.M{"id": bson.M{"$literal": id}, "at": "$$NOW"}
+	}
+
+	return mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"team":         bson.M{"$ifNull": bson.A{"$team", bson.M{"$literal": delta.Team}}},
+		"score":        add("score", delta.Runs),
+		"ballsFaced":   add("ballsFaced", delta.Balls),
+		"fours":        add("fours", delta.Fours),
+		"sixes":        add("sixes", delta.Sixes),
+		"lastPlayed":   delta.LastPlayed,
+		"name":         bson.M{"$literal": delta.Name},
+		"lastOutcome":  bson.M{"$literal": delta.LastOutcome},
+		"appliedBalls": bson.M{"$concatArrays": bson.A{recent, added}},
+	}}}}
+}
+
+func (s *mongoStore) Leaderboard(ctx context.Context) ([]Student, error) {
+	// Sort by score descending, roll number as a stable tie-break
+	opts := options.Find().
+		SetSort(bson.D{{Key: "score", Value: -1}, {Key: "rollNumber", Value: 1}}).
+		SetProjection(withoutAppliedBalls)
+
+	cursor, err := s.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var students []Student
+	if err := cursor.All(ctx, &students); err != nil {
+		return nil, err
+	}
+	return students, nil
+}
+
+func (s *mongoStore) GetStudent(ctx context.Context, rollNumber string) (*Student, error) {
+	var student Student
+	opts := options.FindOne().SetProjection(withoutAppliedBalls)
+	err := s.collection.FindOne(ctx, bson.M{"rollNumber": rollNumber}, opts).Decode(&student)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrStudentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &student, nil
+}
+
+func (s *mongoStore) ReplaceAll(ctx context.Context, students []Student) error {
+	keep := make([]string, 0, len(students))
+	models := make([]mongo.WriteModel, 0, len(students))
+	for _, student := range students {
+		keep = append(keep, student.RollNumber)
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"rollNumber": student.RollNumber}).
+			SetReplacement(student).
+			SetUpsert(true))
+	}
+
+	if len(models) > 0 {
+		if _, err := s.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+	_, err := s.collection.DeleteMany(ctx, bson.M{"rollNumber": bson.M{"$nin": keep}})
+	return err
+}
+
+func (s *mongoStore) AdjustScore(ctx context.Context, rollNumber string, runs int) (*Student, error) {
+	return s.update(ctx, rollNumber, bson.M{"$inc": bson.M{"score": runs}})
+}
+
+func (s *mongoStore) Rename(ctx context.Context, rollNumber, name string) (*Student, error) {
+	return s.update(ctx, rollNumber, bson.M{"$set": bson.M{"name": name}})
+}
+
+// update applies an update to an existing student and returns the result
+func (s *mongoStore) update(ctx context.Context, rollNumber string, update bson.M) (*Student, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(withoutAppliedBalls)
+
+	var student Student
+	err := s.collection.FindOneAndUpdate(ctx, bson.M{"rollNumber": rollNumber}, update, opts).Decode(&student)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrStudentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &student, nil
+}
+
+func (s *mongoStore) Delete(ctx context.Context, rollNumber string) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"rollNumber": rollNumber})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrStudentNotFound
+	}
+	return nil
+}
+
+func (s *mongoStore) ApplyBatch(ctx context.Context, deltas []StudentDelta) error {
+	models := make([]mongo.WriteModel, 0, 2*len(deltas))
+	for _, delta := range deltas {
+		// Create the student first so the guarded update below never has
+		// to upsert; an upsert that misses the guard would collide with
+		// the existing document
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"rollNumber": delta.RollNumber}).
+			SetUpdate(bson.M{"$setOnInsert": bson.M{"rollNumber": delta.RollNumber, "team": delta.Team}}).
+			SetUpsert(true))
+
+		// Every ball of the delta is checked against the student's recent
+		// balls
+		filter := bson.M{"rollNumber": delta.RollNumber, "appliedBalls.id": bson.M{"$nin": delta.BallIDs}}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(deltaUpdate(delta)))
+	}
+	if len(models) == 0 {
+		return nil
+	}
+
+	_, err := s.collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(true))
+	return err
+}
+
+// ---------------------------------------------------------------------------
+// In-memory store
+// ---------------------------------------------------------------------------
+
+// memoryStore keeps students in a map guarded by a mutex. Useful for local
+// demos and handler tests where no MongoDB is available.
+type memoryStore struct {
+	mu       sync.RWMutex
+	students map[string]*Student
+	applied  map[string]time.Time // Balls counted in the last APPLIED_BALLS_RETENTION, and when
+	pruned   time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{students: make(map[string]*Student), applied: make(map[string]time.Time)}
+}
+
+func (s *memoryStore) RecordShot(ctx context.Context, ball BallEvent) (*Student, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	student, exists := s.students[ball.RollNumber]
+	if !exists {
+		student = &Student{RollNumber: ball.RollNumber, Team: ball.Team}
+		s.students[ball.RollNumber] = student
+	}
+	student.applyOutcome(ball.Shot)
+	student.Name = ball.Name
+	student.LastPlayed = ball.Timestamp
+	s.remember([]string{ball.ID}, time.Now())
+
+	updated := *student
+	return &updated, nil
+}
+
+func (s *memoryStore) Leaderboard(ctx context.Context) ([]Student, error) {
+	s.mu.RLock()
+	students := make([]Student, 0, len(s.students))
+	for _, student := range s.students {
+		students = append(students, *student)
+	}
+	s.mu.RUnlock()
+
+	// Sort by score descending, roll number as a stable tie-break
+	sort.Slice(students, func(i, j int) bool {
+		if students[i].Score != students[j].Score {
+			return students[i].Score > students[j].Score
+		}
+		return students[i].RollNumber < students[j].RollNumber
+	})
+	return students, nil
+}
+
+func (s *memoryStore) GetStudent(ctx context.Context, rollNumber string) (*Student, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	student, exists := s.students[rollNumber]
+	if !exists {
+		return nil, ErrStudentNotFound
+	}
+	found := *student
+	return &found, nil
+}
+
+func (s *memoryStore) ReplaceAll(ctx context.Context, students []Student) error {
+	replaced := make(map[string]*Student, len(students))
+	applied := make(map[string]time.Time)
+	for _, student := range students {
+		for _, ball := range student.AppliedBalls {
+			applied[ball.ID] = ball.At
+		}
+		copied := student
+		copied.AppliedBalls = nil
+		replaced[student.RollNumber] = &copied
+	}
+
+	s.mu.Lock()
+	s.students = replaced
+	s.applied = applied
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) AdjustScore(ctx context.Context, rollNumber string, runs int) (*Student, error) {
+	return s.update(rollNumber, func(student *Student) { student.Score += runs })
+}
+
+func (s *memoryStore) Rename(ctx context.Context, rollNumber, name string) (*Student, error) {
+	return s.update(rollNumber, func(student *Student) { student.Name = name })
+}
+
+func (s *memoryStore) update(rollNumber string, change func(*Student)) (*Student, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	student, exists := s.students[rollNumber]
+	if !exists {
+		return nil, ErrStudentNotFound
+	}
+	change(student)
+	updated := *student
+	return &updated, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, rollNumber string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.students[rollNumber]; !exists {
+		return ErrStudentNotFound
+	}
+	delete(s.students, rollNumber)
+	return nil
+}
+
+func (s *memoryStore) ApplyBatch(ctx context.Context, deltas []StudentDelta) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, delta := range deltas {
+		if s.hasAny(delta.BallIDs) {
+			continue
+		}
+		student, exists := s.students[delta.RollNumber]
+		if !exists {
+			student = &Student{RollNumber: delta.RollNumber, Team: delta.Team}
+			s.students[delta.RollNumber] = student
+		}
+		student.Score += delta.Runs
+		student.BallsFaced += delta.Balls
+		student.Fours += delta.Fours
+		student.Sixes += delta.Sixes
+		student.Name = delta.Name
+		student.LastPlayed = delta.LastPlayed
+		student.LastOutcome = delta.LastOutcome
+		s.remember(delta.BallIDs, time.Now())
+	}
+	return nil
+}
+
+// hasAny reports whether any of the balls was counted within the
+// retention
+func (s *memoryStore) hasAny(ballIDs []string) bool {
+	cutoff := time.Now().Add(-APPLIED_BALLS_RETENTION)
+	for _, id := range ballIDs {
+		if at, ok := s.applied[id]; ok && at.After(cutoff) {
+			return true
+		}
+	}
+	return false
+}
+
+// remember records counted balls and, once a minute, forgets those past
+// the retention
+func (s *memoryStore) remember(ballIDs []string, now time.Time) {
+	for _, id := range ballIDs {
+		s.applied[id] = now
+	}
+	if now.Sub(s.pruned) < time.Minute {
+		return
+	}
+	s.pruned = now
+	cutoff := now.Add(-APPLIED_BALLS_RETENTION)
+	for id, at := range s.applied {
+		if !at.After(cutoff) {
+			delete(s.applied, id)
+		}
+	}
+}
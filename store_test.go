@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testBall(roll string, n, runs int) BallEvent {
+	return BallEvent{
+		ID:         fmt.Sprintf("%s-%d", roll, n),
+		EventID:    DEFAULT_EVENT_ID,
+		RollNumber: roll,
+		Name:       "Asha",
+		Shot:       ShotOutcome{Result: fmt.Sprint(runs), Runs: runs},
+	}
+}
+
+func TestApplyBatchSkipsReplays(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	const roll = "2021000001"
+
+	var first, second StudentDelta
+	first.add(testBall(roll, 1, 4))
+	first.add(testBall(roll, 2, 6))
+	second.add(testBall(roll, 3, 1))
+	batch := []StudentDelta{first, second}
+
+	wantScore := func(step string, score, balls int) {
+		t.Helper()
+		student, err := store.GetStudent(ctx, roll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if student.Score != score || student.BallsFaced != balls {
+			t.Errorf("%s: score %d after %d balls, want %d after %d", step, student.Score, student.BallsFaced, score, balls)
+		}
+	}
+
+	if err := store.ApplyBatch(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	wantScore("first write", 11, 3)
+	if err := store.ApplyBatch(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	wantScore("batch replayed", 11, 3)
+
+	// A replay one ball per delta, as the journal does after a crash, and
+	// a ball that went through RecordShot are recognised too
+	if _, err := store.RecordShot(ctx, testBall(roll, 4, 2)); err != nil {
+		t.Fatal(err)
+	}
+	var single StudentDelta
+	single.add(testBall(roll, 4, 2))
+	if err := store.ApplyBatch(ctx, []StudentDelta{single}); err != nil {
+		t.Fatal(err)
+	}
+	wantScore("recorded ball replayed", 13, 4)
+
+	// A rebuild keeps the applied balls, so an in-flight batch whose balls
+	// already made it to the ball log is not counted on top of the totals
+	students, err := store.Leaderboard(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, id := range []string{first.BallIDs[0], first.BallIDs[1], second.BallIDs[0], single.BallIDs[0]} {
+		students[0].AppliedBalls = append(students[0].AppliedBalls, appliedBall{ID: id, At: now})
+	}
+	if err := store.ReplaceAll(ctx, students); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ApplyBatch(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	wantScore("replayed after a rebuild", 13, 4)
+}
+
+func TestAppliedBallsExpire(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	const roll = "2021000002"
+
+	var old, recent StudentDelta
+	old.add(testBall(roll, 1, 4))
+	recent.add(testBall(roll, 2, 6))
+	if err := store.ApplyBatch(ctx, []StudentDelta{old, recent}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Backdate the first ball past the retention; the next write forgets it
+	now := time.Now()
+	store.applied[old.BallIDs[0]] = now.Add(-APPLIED_BALLS_RETENTION - time.Minute)
+	store.pruned = time.Time{}
+	store.remember(nil, now)
+
+	tests := []struct {
+		ball       string
+		remembered bool
+	}{
+		{old.BallIDs[0], false},
+		{recent.BallIDs[0], true},
+	}
+	for _, tt := range tests {
+		if _, ok := store.applied[tt.ball]; ok != tt.remembered {
+			t.Errorf("ball %s: remembered %v, want %v", tt.ball, ok, tt.remembered)
+		}
+	}
+
+	// Rebuilt totals only carry the balls still inside the retention
+	stale := testBall(roll, 3, 1)
+	stale.Timestamp = now.Add(-APPLIED_BALLS_RETENTION - time.Hour)
+	fresh := testBall(roll, 4, 2)
+	fresh.Timestamp = now
+	log := &memoryBallLog{ids: make(map[string]bool)}
+	if err := log.AppendMany(ctx, []BallEvent{stale, fresh}); err != nil {
+		t.Fatal(err)
+	}
+	totals, err := totalsFromBallLog(ctx, log, DEFAULT_EVENT_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || len(totals[0].AppliedBalls) != 1 || totals[0].AppliedBalls[0].ID != fresh.ID {
+		t.Errorf("rebuilt applied balls %+v, want only %s", totals, fresh.ID)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// House names from TEAM_NAMES; empty means team mode is off
+	teamNames []string
+	// How players without a registered team are placed: "last-digit" or "none"
+	teamRule string
+)
+
+// loadTeamConfig takes TEAM_NAMES (e.g. "Red,Blue,Green,Yellow") and
+// TEAM_RULE from the config
+func loadTeamConfig() {
+	teamNames = currentConfig().Players.TeamNames
+	teamRule = currentConfig().Players.TeamRule
+
+	if len(teamNames) > 0 {
+		logger.Info("team mode on", "teams", teamNames, "rule", teamRule)
+	}
+}
+
+// assignTeam picks the team for a hit. A requested team must be one of
+// TEAM_NAMES; otherwise the team rule decides. Stores only keep the team
+// from a player's first hit.
+func assignTeam(rollNumber, requested string) (string, error) {
+	if len(teamNames) == 0 {
+		return "", nil
+	}
+	if requested != "" {
+		for _, name := range teamNames {
+			if strings.EqualFold(name, strings.TrimSpace(requested)) {
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("Unknown team. Choose one of: %s", strings.Join(teamNames, ", "))
+	}
+	return teamByRule(rollNumber), nil
+}
+
+// teamByRule spreads roll numbers across teams by their last digit
+func teamByRule(rollNumber string) string {
+	if len(teamNames) == 0 || teamRule != "last-digit" || rollNumber == "" {
+		return ""
+	}
+	digit := int(rollNumber[len(rollNumber)-1] - '0')
+	return teamNames[digit%len(teamNames)]
+}
+
+// teamOf is the student's stored team, or the rule's pick for students who
+// played before team mode was switched on
+func teamOf(student *Student) string {
+	if student.Team != "" {
+		return student.Team
+	}
+	return teamByRule(student.RollNumber)
+}
+
+// TeamStanding is one row of the team scoreboard
+type TeamStanding struct {
+	Rank    int     `json:"rank"`
+	Team    string  `json:"team"`
+	Runs    int     `json:"runs"`
+	Players int     `json:"players"`
+	Average float64 `json:"average"` // Runs per player
+}
+
+// TeamBoard keeps running team totals for one event. It remembers what each
+// student last contributed, so applying an updated student only moves the
+// difference.
+type TeamBoard struct {
+	mu      sync.RWMutex
+	members map[string]teamMember
+	totals  map[string]*TeamStanding
+}
+
+type teamMember struct {
+	team string
+	runs int
+}
+
+func NewTeamBoard() *TeamBoard {
+	return &TeamBoard{
+		members: make(map[string]teamMember),
+		totals:  make(map[string]*TeamStanding),
+	}
+}
+
+// Load rebuilds the totals from a full list of students
+func (tb *TeamBoard) Load(students []Student) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.members = make(map[string]teamMember, len(students))
+	tb.totals = make(map[string]*TeamStanding)
+	for i := range students {
+		tb.apply(&students[i])
+	}
+}
+
+// Apply records a student's latest totals
+func (tb *TeamBoard) Apply(student Student) {
+	tb.mu.Lock()
+	tb.apply(&student)
+	tb.mu.Unlock()
+}
+
+// Remove drops a student's contribution
+func (tb *TeamBoard) Remove(rollNumber string) {
+	tb.mu.Lock()
+	tb.remove(rollNumber)
+	tb.mu.Unlock()
+}
+
+func (tb *TeamBoard) apply(student *Student) {
+	tb.remove(student.RollNumber)
+
+	team := teamOf(student)
+	if team == "" {
+		return
+	}
+	total, exists := tb.totals[team]
+	if !exists {
+		total = &TeamStanding{Team: team}
+		tb.totals[team] = total
+	}
+	total.Runs += student.Score
+	total.Players++
+	tb.members[student.RollNumber] = teamMember{team: team, runs: student.Score}
+}
+
+func (tb *TeamBoard) remove(rollNumber string) {
+	member, exists := tb.members[rollNumber]
+	if !exists {
+		return
+	}
+	total := tb.totals[member.team]
+	total.Runs -= member.runs
+	total.Players--
+	if total.Players == 0 {
+		delete(tb.totals, member.team)
+	}
+	delete(tb.members, rollNumber)
+}
+
+// Standings ranks teams by total runs, or by runs per player when byAverage
+// is set. Configured teams with no players yet are listed with zeros.
+func (tb *TeamBoard) Standings(byAverage bool) []TeamStanding {
+	tb.mu.RLock()
+	standings := make([]TeamStanding, 0, len(tb.totals))
+	for _, total := range tb.totals {
+		standings = append(standings, *total)
+	}
+	tb.mu.RUnlock()
+
+	for _, name := range teamNames {
+		found := false
+		for _, standing := range standings {
+			found = found || standing.Team == name
+		}
+		if !found {
+			standings = append(standings, TeamStanding{Team: name})
+		}
+	}
+
+	metric := func(t TeamStanding) float64 {
+		if byAverage {
+			return t.Average
+		}
+		return float64(t.Runs)
+	}
+	for i := range standings {
+		if standings[i].Players > 0 {
+			standings[i].Average = math.Round(float64(standings[i].Runs)/float64(standings[i].Players)*100) / 100
+		}
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		if metric(standings[i]) != metric(standings[j]) {
+			return metric(standings[i]) > metric(standings[j])
+		}
+		return standings[i].Team < standings[j].Team
+	})
+
+	// Tied teams share a rank
+	for i := range standings {
+		standings[i].Rank = i + 1
+		if i > 0 && metric(standings[i]) == metric(standings[i-1]) {
+			standings[i].Rank = standings[i-1].Rank
+		}
+	}
+	return standings
+}
+
+// getTeamScoreboard serves GET /teams/scoreboard[?sort=average]
+func getTeamScoreboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json; charset=UTF-8")
+
+	ev, ok := eventFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy != "" && sortBy != "runs" && sortBy != "average" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "sort must be \"runs\" or \"average\""})
+		return
+	}
+
+	json.NewEncoder(w).Encode(ev.teams.Standings(sortBy == "average"))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestTeamBoardStandings(t *testing.T) {
+	savedNames, savedRule := teamNames, teamRule
+	teamNames, teamRule = []string{"Bears", "Lions", "Tigers"}, "last-digit"
+	t.Cleanup(func() { teamNames, teamRule = savedNames, savedRule })
+
+	board := NewTeamBoard()
+	board.Load([]Student{
+		{RollNumber: "2021000001", Team: "Lions", Score: 10},
+		{RollNumber: "2021000002", Team: "Lions", Score: 20},
+		{RollNumber: "2021000003", Team: "Tigers", Score: 25},
+		{RollNumber: "2021000004", Score: 5}, // Played before team mode: 4 % 3 puts them in Lions
+	})
+
+	tests := []struct {
+		step      string
+		change    func()
+		byAverage bool
+		want      []TeamStanding
+	}{
+		{
+			"loaded, by runs",
+			func() {},
+			false,
+			[]TeamStanding{
+				{Rank: 1, Team: "Lions", Runs: 35, Players: 3, Average: 11.67},
+				{Rank: 2, Team: "Tigers", Runs: 25, Players: 1, Average: 25},
+				{Rank: 3, Team: "Bears"},
+			},
+		},
+		{
+			"loaded, by average",
+			func() {},
+			true,
+			[]TeamStanding{
+				{Rank: 1, Team: "Tigers", Runs: 25, Players: 1, Average: 25},
+				{Rank: 2, Team: "Lions", Runs: 35, Players: 3, Average: 11.67},
+				{Rank: 3, Team: "Bears"},
+			},
+		},
+		{
+			"a new score only moves the difference",
+			func() { board.Apply(Student{RollNumber: "2021000003", Team: "Tigers", Score: 35}) },
+			false,
+			[]TeamStanding{
+				{Rank: 1, Team: "Lions", Runs: 35, Players: 3, Average: 11.67},
+				{Rank: 1, Team: "Tigers", Runs: 35, Players: 1, Average: 35},
+				{Rank: 3, Team: "Bears"},
+			},
+		},
+		{
+			"removed players take their runs with them",
+			func() { board.Remove("2021000002"); board.Remove("2021000004") },
+			false,
+			[]TeamStanding{
+				{Rank: 1, Team: "Tigers", Runs: 35, Players: 1, Average: 35},
+				{Rank: 2, Team: "Lions", Runs: 10, Players: 1, Average: 10},
+				{Rank: 3, Team: "Bears"},
+			},
+		},
+		{
+			"a team's last player leaving keeps the team listed",
+			func() { board.Remove("2021000003") },
+			false,
+			[]TeamStanding{
+				{Rank: 1, Team: "Lions", Runs: 10, Players: 1, Average: 10},
+				{Rank: 2, Team: "Bears"},
+				{Rank: 2, Team: "Tigers"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt.change()
+		if got := board.Standings(tt.byAverage); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestTeamScoreboardRoute(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"TEAM_NAMES":            "Lions,Tigers",
+		"SHOT_WEIGHTS":          "4=1",
+		"RATE_LIMIT_ROLL_BURST": "10",
+	})
+	hits := []struct {
+		body string
+		want int
+	}{
+		{`{"rollNumber":"2021000001","name":"Asha","team":"lions"}`, http.StatusOK},
+		{`{"rollNumber":"2021000001","name":"Asha","team":"Tigers"}`, http.StatusOK}, // The first hit's team sticks
+		{`{"rollNumber":"2021000002","name":"Ravi","team":"Tigers"}`, http.StatusOK},
+		{`{"rollNumber":"2021000003","name":"Meera"}`, http.StatusOK}, // Last digit 3 puts them in Tigers
+		{`{"rollNumber":"2021000004","name":"Kiran","team":"Bears"}`, http.StatusBadRequest},
+	}
+	for _, hit := range hits {
+		if rec := serveJSON(server, "POST", "/hit", hit.body); rec.Code != hit.want {
+			t.Fatalf("%s: got %d, want %d: %s", hit.body, rec.Code, hit.want, rec.Body.String())
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []TeamStanding
+	}{
+		{"", []TeamStanding{ // Tied on runs
+			{Rank: 1, Team: "Lions", Runs: 8, Players: 1, Average: 8},
+			{Rank: 1, Team: "Tigers", Runs: 8, Players: 2, Average: 4},
+		}},
+		{"?sort=runs", []TeamStanding{
+			{Rank: 1, Team: "Lions", Runs: 8, Players: 1, Average: 8},
+			{Rank: 1, Team: "Tigers", Runs: 8, Players: 2, Average: 4},
+		}},
+		{"?sort=average", []TeamStanding{
+			{Rank: 1, Team: "Lions", Runs: 8, Players: 1, Average: 8},
+			{Rank: 2, Team: "Tigers", Runs: 8, Players: 2, Average: 4},
+		}},
+	}
+	for _, tt := range tests {
+		rec := serveJSON(server, "GET", "/teams/scoreboard"+tt.query, "")
+		var got []TeamStanding
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%q: %v in %s", tt.query, err, rec.Body.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+	if rec := serveJSON(server, "GET", "/teams/scoreboard?sort=name", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown sort: got %d, want 400", rec.Code)
+	}
+}